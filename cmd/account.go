@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func runRegister(args []string) error {
+	fs := flag.NewFlagSet("register", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *sf.email == "" {
+		return errors.New("missing -email")
+	}
+
+	if err := sf.connect(); err != nil {
+		return err
+	}
+
+	// With -ssh-key the keys are derived from the SSH key, whose public
+	// half is enough to register.
+	var publicKey, signingPublicKey []byte
+	if *sf.sshKey != "" {
+		var err error
+		if publicKey, signingPublicKey, err = sshPublicKeys(*sf.sshKey); err != nil {
+			return err
+		}
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	if publicKey != nil {
+		return services.RegisterSSH(*sf.email, password, publicKey, signingPublicKey)
+	}
+	return services.Register(*sf.email, password)
+}
+
+func runProject(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: project create|share ...")
+	}
+
+	switch args[0] {
+	case "create":
+		return projectCreate(args[1:])
+	case "share":
+		return projectShare(args[1:])
+	default:
+		return fmt.Errorf("unknown project command %q", args[0])
+	}
+}
+
+func projectCreate(args []string) error {
+	fs := flag.NewFlagSet("project create", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: project create NAME")
+	}
+
+	s, err := sf.login()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if err := services.CreateProject(positional[0], s.UserId, s.KeyPair.PublicKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created project %s.\n", positional[0])
+	return nil
+}
+
+func projectShare(args []string) error {
+	fs := flag.NewFlagSet("project share", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	force := fs.Bool("force", false, "share even though the recipient's key was not verified")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: project share EMAIL")
+	}
+	recipient := positional[0]
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	key, err := services.LookupUserKey(recipient)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Fingerprint of %s: %s\n", recipient, key.Fingerprint())
+	if !key.Verified {
+		if !*force {
+			return fmt.Errorf("the key of %s is not verified, compare the fingerprint with them and run `trust verify %s`, or pass -force", recipient, recipient)
+		}
+		fmt.Println("WARNING: sharing with an unverified key.")
+	}
+
+	if err := services.ShareProject(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.WrappedKey, recipient, *force); err != nil {
+		return err
+	}
+
+	fmt.Printf("Shared project %s with %s.\n", *sf.project, recipient)
+	return nil
+}
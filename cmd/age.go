@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// listFlag collects a flag given several times, or once with comma
+// separated values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// envExport encrypts the latest version to age recipients, so it can be
+// handed to people without an envcrypt account.
+func envExport(args []string) error {
+	fs := flag.NewFlagSet("env export", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	var recipients listFlag
+	fs.Var(&recipients, "age", "age1... recipient or email of an envcrypt user, repeatable")
+	armor := fs.Bool("armor", false, "write PEM-like text instead of binary")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	force := fs.Bool("force", false, "encrypt to users whose key is not verified")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 || len(recipients) == 0 {
+		return errors.New("usage: env export -age RECIPIENT [-age RECIPIENT...] [-armor] [-o FILE]")
+	}
+	if *output == "" && !*armor && isTerminal(os.Stdout) {
+		return errors.New("refusing to write binary age output to a terminal, use -armor or -o")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	keys, err := services.AgeRecipients(recipients, *force)
+	if err != nil {
+		return err
+	}
+
+	encrypted, version, err := services.ExportEnvAge(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey, keys, *armor)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(encrypted)
+		return err
+	}
+	if err := os.WriteFile(*output, encrypted, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported version %d of %s to %s.\n", version, s.EnvName, *output)
+	return nil
+}
+
+// envImport merges an age encrypted .env file into the environment. It is
+// decrypted with the identities in -age-identity, or else with the user's
+// own key, which is what files exported to their email are encrypted to.
+func envImport(args []string) error {
+	fs := flag.NewFlagSet("env import", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	identityFile := fs.String("age-identity", "", "file with AGE-SECRET-KEY-1... identities, defaults to your envcrypt key")
+	replace := fs.Bool("replace", false, "remove keys missing from the file")
+	dryRun := fs.Bool("dry-run", false, "show the changes without pushing")
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env import FILE [-age-identity FILE]")
+	}
+
+	data, err := readInput(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var identities []*cryptutils.SecureBuffer
+	if *identityFile != "" {
+		keys, err := os.ReadFile(*identityFile)
+		if err != nil {
+			return err
+		}
+		identities, err = cryptutils.ParseAgeIdentities(keys)
+		clear(keys)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *identityFile, err)
+		}
+		defer func() {
+			for _, identity := range identities {
+				identity.Destroy()
+			}
+		}()
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	keys := [][]byte{s.KeyPair.PrivateKey}
+	if identities != nil {
+		keys = keys[:0]
+		for _, identity := range identities {
+			keys = append(keys, identity.Bytes())
+		}
+	}
+
+	imported, err := services.ReadAgeEnv(data, keys)
+	if err != nil {
+		return fmt.Errorf("%s: %w", positional[0], err)
+	}
+
+	current, version, err := services.PullLatestEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	updated := imported
+	if !*replace {
+		updated = maps.Clone(current)
+		if updated == nil {
+			updated = make(map[string]string)
+		}
+		maps.Copy(updated, imported)
+	}
+
+	diff := cryptutils.DiffEnvVersions(current, updated)
+	if len(diff.Added)+len(diff.Removed)+len(diff.Modified) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	printMaskedDiff(os.Stdout, diff)
+	if *dryRun {
+		return nil
+	}
+
+	metadata := services.Metadata{
+		Type:    "env_imported",
+		Keys:    services.DiffKeys(current, updated),
+		Message: *message,
+	}
+
+	return services.ReplaceEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, updated, version, s.WrappedKey, metadata)
+}
+
+// readInput reads a file, or stdin for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
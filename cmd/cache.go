@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func runCache(args []string) error {
+	if len(args) != 1 || args[0] != "clear" {
+		return errors.New("usage: cache clear")
+	}
+
+	if err := services.ClearCache(); err != nil {
+		return err
+	}
+
+	fmt.Println("Cleared the local cache.")
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+type describeResult struct {
+	Key string `json:"key"`
+	cryptutils.KeyInfo
+}
+
+// envDescribe shows what keys are for, or with any of -description, -owner,
+// -tags or -runbook changes it for a single key. An empty value clears the
+// field. Values are never shown.
+func envDescribe(args []string) error {
+	fs := flag.NewFlagSet("env describe", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	description := fs.String("description", "", "what the key is for")
+	owner := fs.String("owner", "", "who to ask about the key")
+	var setTags listFlag
+	fs.Var(&setTags, "tags", "replace the tags of the key, comma separated")
+	runbook := fs.String("runbook", "", "URL of the runbook for the key")
+	var tags listFlag
+	fs.Var(&tags, "tag", "only show keys with this tag, repeatable")
+	format := fs.String("format", "text", "output format: text or json")
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	edits := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "description", "owner", "tags", "runbook":
+			edits[f.Name] = true
+		}
+	})
+	if len(edits) > 0 && len(positional) != 1 {
+		return errors.New("usage: env describe KEY [-description TEXT] [-owner WHO] [-tags A,B] [-runbook URL]")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use text or json", *format)
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if len(edits) > 0 {
+		metadata := services.Metadata{
+			Type:    "env_key_described",
+			Message: *message,
+		}
+		return services.UpdateKeyInfo(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, positional[0], s.WrappedKey, metadata, func(info *cryptutils.KeyInfo) {
+			if edits["description"] {
+				info.Description = *description
+			}
+			if edits["owner"] {
+				info.Owner = *owner
+			}
+			if edits["tags"] {
+				info.Tags = slices.Clone(setTags)
+			}
+			if edits["runbook"] {
+				info.Runbook = *runbook
+			}
+		})
+	}
+
+	latest, err := services.PullLatestVersion(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		return fmt.Errorf("%s has no versions yet", s.EnvName)
+	}
+
+	env := latest.Env
+	if len(tags) > 0 {
+		env = services.WithTags(latest, env, tags)
+	}
+
+	keys := positional
+	if len(keys) == 0 {
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	results := []describeResult{}
+	for _, key := range keys {
+		if _, exists := env[key]; !exists {
+			if _, set := latest.Env[key]; !set {
+				return fmt.Errorf("key %s is not set", key)
+			}
+			continue
+		}
+		results = append(results, describeResult{Key: key, KeyInfo: latest.KeyInfo[key]})
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	if len(results) == 0 {
+		fmt.Printf("No keys of %s match.\n", s.EnvName)
+		return nil
+	}
+
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(r.Key)
+		if r.IsZero() {
+			fmt.Println("  (not described)")
+			continue
+		}
+		if r.Description != "" {
+			fmt.Printf("  %s\n", strings.ReplaceAll(r.Description, "\n", "\n  "))
+		}
+		if r.Owner != "" {
+			fmt.Printf("  Owner:   %s\n", r.Owner)
+		}
+		if len(r.Tags) > 0 {
+			fmt.Printf("  Tags:    %s\n", strings.Join(r.Tags, ", "))
+		}
+		if r.Runbook != "" {
+			fmt.Printf("  Runbook: %s\n", r.Runbook)
+		}
+		if !r.Expires.IsZero() {
+			status := "in " + formatPeriod(time.Until(r.Expires))
+			if !r.Expires.After(time.Now()) {
+				status = "expired"
+			}
+			fmt.Printf("  Expires: %s (%s)\n", formatTime(r.Expires), status)
+		}
+	}
+	return nil
+}
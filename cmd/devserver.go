@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/envcrypts/envcrypt_cli/internal/devserver"
+)
+
+func runDevServer(args []string) error {
+	fs := flag.NewFlagSet("dev-server", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	data := fs.String("data", "", "JSON file to persist state to, in-memory when empty")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	store := devserver.NewMemoryStore()
+	if *data != "" {
+		var err error
+		if store, err = devserver.OpenFileStore(*data); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("envcrypt dev server listening on http://%s", *addr)
+	return http.ListenAndServe(*addr, devserver.New(store))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func envEdit(args []string) error {
+	fs := flag.NewFlagSet("env edit", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	message := fs.String("m", "", "change message")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	current, version, err := services.PullLatestEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	path, cleanup, err := secureTempFile(cryptutils.NormalizeEnv(current))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var editing atomic.Bool
+	stop := cleanupOnSignal(cleanup, &editing)
+	defer stop()
+
+	for {
+		edited, err := editEnv(path, &editing)
+		if err != nil {
+			return err
+		}
+
+		diff := cryptutils.DiffEnvVersions(current, edited)
+		if len(diff.Added)+len(diff.Removed)+len(diff.Modified) == 0 {
+			fmt.Println("No changes.")
+			return nil
+		}
+
+		printMaskedDiff(os.Stdout, diff)
+
+		ok, err := confirm(fmt.Sprintf("Push these changes to %s as a new version?", s.EnvName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("edit aborted, nothing pushed")
+		}
+
+		metadata := services.Metadata{
+			Type:    "env_edited",
+			Keys:    services.DiffKeys(current, edited),
+			Message: *message,
+		}
+
+		err = services.ReplaceEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, edited, version, s.WrappedKey, metadata)
+		if err == nil {
+			return nil
+		}
+
+		// Keep the edits around until the user gives up on them. Somebody
+		// may have pushed in the meantime, so the next diff is against
+		// the version that is latest then.
+		fmt.Fprintln(os.Stderr, "error:", err)
+		retry, cerr := confirm("Re-open the editor with your changes?")
+		if cerr != nil || !retry {
+			return errors.New("edit aborted, your changes were discarded")
+		}
+		current, version, err = services.PullLatestEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+		if err != nil {
+			return fmt.Errorf("%w\nyour changes were discarded", err)
+		}
+	}
+}
+
+// editEnv runs the editor on path until it holds a valid env.
+func editEnv(path string, editing *atomic.Bool) (map[string]string, error) {
+	for {
+		editing.Store(true)
+		err := runEditor(path)
+		editing.Store(false)
+		if err != nil {
+			return nil, err
+		}
+
+		edited, err := readEditedEnv(path)
+		if err == nil {
+			return edited, nil
+		}
+
+		fmt.Fprintln(os.Stderr, "error:", err)
+		retry, cerr := confirm("Re-open the editor?")
+		if cerr != nil {
+			return nil, cerr
+		}
+		if !retry {
+			return nil, errors.New("edit aborted, nothing pushed")
+		}
+	}
+}
+
+func readEditedEnv(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := cryptutils.ParseEnv(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range env {
+		if err := cryptutils.ValidateEnvEntry(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return env, nil
+}
+
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s: %w", editor, err)
+	}
+
+	return nil
+}
+
+// secureTempFile writes data to a 0600 file inside a private 0700 directory,
+// preferring memory-backed filesystems so plaintext never reaches disk. The
+// returned cleanup overwrites the file before removing it.
+func secureTempFile(data []byte) (string, func(), error) {
+	var base string
+	for _, candidate := range []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm"} {
+		if candidate == "" {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			base = candidate
+			break
+		}
+	}
+	if base == "" {
+		base = os.TempDir()
+		fmt.Fprintln(os.Stderr, "warning: no tmpfs found, decrypted env is written to", base)
+	}
+
+	dir, err := os.MkdirTemp(base, "envcrypt-edit-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := filepath.Join(dir, ".env")
+	cleanup := func() {
+		shredFile(path)
+		os.RemoveAll(dir)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return path, cleanup, nil
+}
+
+// cleanupOnSignal runs cleanup and exits when envcrypt is interrupted or
+// terminated, so the plaintext does not outlive it. Ctrl-C while editing
+// also reaches the editor, which decides what it means, so an interrupt is
+// ignored while editing is set. The returned stop removes the handler.
+func cleanupOnSignal(cleanup func(), editing *atomic.Bool) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if sig == os.Interrupt && editing.Load() {
+					continue
+				}
+				cleanup()
+				fmt.Fprintln(os.Stderr, "\nerror: edit aborted, nothing pushed")
+				if sig == os.Interrupt {
+					os.Exit(130)
+				}
+				os.Exit(143)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func shredFile(path string) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	zeros := make([]byte, 4096)
+	for remaining := info.Size(); remaining > 0; remaining -= int64(len(zeros)) {
+		n := int64(len(zeros))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			return
+		}
+	}
+	f.Sync()
+}
+
+// printMaskedDiff prints the changed keys without revealing any value.
+func printMaskedDiff(w io.Writer, diff cryptutils.DiffingResult) {
+	lines := make([]string, 0, len(diff.Added)+len(diff.Removed)+len(diff.Modified))
+	for _, key := range diff.Added {
+		lines = append(lines, "+ "+key+"=********")
+	}
+	for _, key := range diff.Modified {
+		lines = append(lines, "~ "+key+"=********")
+	}
+	for _, key := range diff.Removed {
+		lines = append(lines, "- "+key)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func runEnv(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing env subcommand")
+	}
+
+	switch args[0] {
+	case "set":
+		return envSet(args[1:])
+	case "unset":
+		return envUnset(args[1:])
+	case "get":
+		return envGet(args[1:])
+	case "pull":
+		return envPull(args[1:])
+	case "edit":
+		return envEdit(args[1:])
+	case "log":
+		return envLog(args[1:])
+	case "history":
+		return envHistory(args[1:])
+	case "blame":
+		return envBlame(args[1:])
+	case "rollback":
+		return envRollback(args[1:])
+	case "verify":
+		return envVerify(args[1:])
+	case "export":
+		return envExport(args[1:])
+	case "import":
+		return envImport(args[1:])
+	case "lint":
+		return envLint(args[1:])
+	case "generate":
+		return envGenerate(args[1:])
+	case "stale":
+		return envStale(args[1:])
+	case "expire":
+		return envExpire(args[1:])
+	case "expiring":
+		return envExpiring(args[1:])
+	case "describe":
+		return envDescribe(args[1:])
+	default:
+		return fmt.Errorf("unknown env subcommand %q", args[0])
+	}
+}
+
+func envSet(args []string) error {
+	fs := flag.NewFlagSet("env set", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	fromStdin := fs.Bool("stdin", false, "read the value from stdin")
+	expiresAt := fs.String("expires", "", "when the value expires: a date, RFC 3339 time or period like 30d")
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env set KEY=VALUE | env set KEY --stdin")
+	}
+
+	var key, value string
+	if *fromStdin {
+		if strings.Contains(positional[0], "=") {
+			return errors.New("--stdin takes a bare KEY")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		key = positional[0]
+		value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	} else {
+		parts := strings.SplitN(positional[0], "=", 2)
+		if len(parts) != 2 {
+			return errors.New("expected KEY=VALUE, or use --stdin")
+		}
+		key, value = parts[0], parts[1]
+	}
+
+	var expires time.Time
+	if *expiresAt != "" {
+		if expires, err = services.ParseExpiry(*expiresAt, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return services.SetEnvKey(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, key, value, expires, s.WrappedKey, *message)
+}
+
+func envUnset(args []string) error {
+	fs := flag.NewFlagSet("env unset", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env unset KEY")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return services.UnsetEnvKey(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, positional[0], s.WrappedKey, *message)
+}
+
+func envGet(args []string) error {
+	fs := flag.NewFlagSet("env get", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env get KEY")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	value, err := services.GetEnvKey(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, positional[0], s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(value)
+	return nil
+}
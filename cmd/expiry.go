@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// envExpire sets or clears when the value of a key expires, without
+// changing the value.
+func envExpire(args []string) error {
+	fs := flag.NewFlagSet("env expire", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return errors.New("usage: env expire KEY DATE|PERIOD|never")
+	}
+
+	var expires time.Time
+	if positional[1] != "never" {
+		if expires, err = services.ParseExpiry(positional[1], time.Now()); err != nil {
+			return err
+		}
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	metadata := services.Metadata{
+		Type:    "env_key_expiry",
+		Message: *message,
+	}
+	err = services.UpdateKeyInfo(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, positional[0], s.WrappedKey, metadata, func(info *cryptutils.KeyInfo) {
+		info.Expires = expires
+	})
+	if err != nil {
+		return err
+	}
+
+	if expires.IsZero() {
+		fmt.Printf("%s no longer expires.\n", positional[0])
+	} else {
+		fmt.Printf("%s expires %s.\n", positional[0], formatTime(expires))
+	}
+	return nil
+}
+
+type expiringResult struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired"`
+}
+
+// envExpiring lists keys that expired or expire soon, and fails if any
+// already expired.
+func envExpiring(args []string) error {
+	fs := flag.NewFlagSet("env expiring", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	within := fs.String("within", "7d", "list keys that expire within this period")
+	format := fs.String("format", "text", "output format: text or json")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use text or json", *format)
+	}
+	period, err := services.ParseDuration(*within)
+	if err != nil {
+		return err
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	latest, err := services.PullLatestVersion(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	results := []expiringResult{}
+	expired := 0
+	for _, k := range services.ExpiringKeys(latest, now, period) {
+		results = append(results, expiringResult{Key: k.Key, Expires: k.Expires, Expired: k.Expired(now)})
+		if k.Expired(now) {
+			expired++
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else if len(results) == 0 {
+		fmt.Printf("No keys of %s expire within %s.\n", s.EnvName, *within)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tEXPIRES\tSTATUS")
+		for _, r := range results {
+			status := "in " + formatPeriod(r.Expires.Sub(now))
+			if r.Expired {
+				status = "expired"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Key, formatTime(r.Expires), status)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if expired > 0 {
+		return fmt.Errorf("%d keys of %s have expired", expired, s.EnvName)
+	}
+	return nil
+}
+
+func formatPeriod(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int((d+24*time.Hour-1)/(24*time.Hour)))
+	}
+	return d.Round(time.Minute).String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// envGenerate sets keys to random values and pushes them as one version.
+// Named keys are always replaced, which is how a secret is rotated; without
+// names, every key with a generate rule in the schema that is not set yet
+// is filled in. Values are only printed with -print.
+func envGenerate(args []string) error {
+	fs := flag.NewFlagSet("env generate", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	kind := fs.String("type", "", "password, hex, base64, uuid or alnum, defaults to the schema's rule or password")
+	length := fs.Int("length", 0, "characters for password and alnum, random bytes for hex and base64")
+	rotate := fs.Bool("rotate", false, "without KEY, also replace schema keys that are already set")
+	show := fs.Bool("print", false, "print the generated values")
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var rules map[string]cryptutils.SecretPolicy
+	schema, path, err := services.FindSchema()
+	if err != nil {
+		return err
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if schema != nil {
+		rules = schema.GeneratePolicies(s.EnvName)
+	}
+
+	policies := make(map[string]cryptutils.SecretPolicy)
+	if len(positional) == 0 {
+		if *kind != "" || *length != 0 {
+			return errors.New("-type and -length need a KEY, schema keys use their generate rule")
+		}
+		if len(rules) == 0 {
+			if schema == nil {
+				return errors.New("usage: env generate KEY [-type T -length N], or add generate rules to " + services.SchemaFileName)
+			}
+			return fmt.Errorf("%s declares no generate rules for %s", path, s.EnvName)
+		}
+		policies = rules
+	}
+	for _, key := range positional {
+		policy, ok := rules[key]
+		if !ok {
+			policy = cryptutils.SecretPolicy{Type: "password"}
+		}
+		if *kind != "" {
+			policy = cryptutils.SecretPolicy{Type: *kind}
+		}
+		if *length != 0 {
+			policy.Length = *length
+		}
+		if err := policy.Check(); err != nil {
+			return err
+		}
+		policies[key] = policy
+	}
+
+	generated, err := services.GenerateEnvKeys(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, policies, len(positional) > 0 || *rotate, s.WrappedKey, *message)
+	if err != nil {
+		return err
+	}
+	if len(generated) == 0 {
+		fmt.Printf("Every key with a generate rule is already set in %s, use -rotate to replace them.\n", s.EnvName)
+		return nil
+	}
+
+	keys := make([]string, 0, len(generated))
+	for key := range generated {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if *show {
+			fmt.Printf("%s=%s\n", key, generated[key])
+		} else {
+			fmt.Printf("Generated %s (%s) in %s.\n", key, policies[key], s.EnvName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+type lintResult struct {
+	File string `json:"file"`
+	cryptutils.LintIssue
+}
+
+// envLint checks local .env files before they are pushed. It needs no
+// account, so it can run in CI on checked out files.
+func envLint(args []string) error {
+	fs := flag.NewFlagSet("env lint", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text, json or github (workflow annotations)")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" && *format != "github" {
+		return fmt.Errorf("unknown format %q, use text, json or github", *format)
+	}
+	if len(positional) == 0 {
+		positional = []string{".env"}
+	}
+
+	results := []lintResult{}
+	for _, path := range positional {
+		data, err := readInput(path)
+		if err != nil {
+			return err
+		}
+		for _, issue := range cryptutils.LintEnv(data) {
+			results = append(results, lintResult{File: path, LintIssue: issue})
+		}
+		clear(data)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Severity == cryptutils.LintError || *strict {
+			failed++
+		}
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	case "github":
+		for _, r := range results {
+			fmt.Printf("::%s file=%s,line=%d,title=%s::%s\n", r.Severity, r.File, r.Line, r.Rule, githubEscape(r.Message))
+		}
+	default:
+		for _, r := range results {
+			fmt.Printf("%s:%d: %s: %s [%s]\n", r.File, r.Line, r.Severity, r.Message, r.Rule)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d issues must be fixed", failed, len(results))
+	}
+	if *format == "text" && len(results) == 0 {
+		fmt.Println("No issues found.")
+	}
+	return nil
+}
+
+// githubEscape escapes the characters workflow commands treat specially.
+func githubEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
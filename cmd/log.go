@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func envLog(args []string) error {
+	fs := flag.NewFlagSet("env log", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	limit := fs.Int("n", 10, "versions per page")
+	page := fs.Int("page", 1, "page to show, newest versions first")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *limit < 1 || *page < 1 {
+		return errors.New("-n and -page must be positive")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// A broken hash chain is reported after the log, which helps to see
+	// where the history was tampered with.
+	history, chainErr := services.PullEnvHistory(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if _, ok := chainErr.(*services.ChainError); chainErr != nil && !ok {
+		return chainErr
+	}
+	changes := services.VersionChanges(history)
+
+	end := len(history) - (*page-1)*(*limit)
+	start := max(end-*limit, 0)
+	if end <= 0 {
+		fmt.Println("No versions on this page.")
+		return chainErr
+	}
+
+	for i := end - 1; i >= start; i-- {
+		services.WriteVersionLog(os.Stdout, history[i], &changes[i])
+	}
+
+	if start > 0 {
+		fmt.Printf("-- page %d of %d, use -page %d for older versions --\n", *page, (len(history)+*limit-1)/(*limit), *page+1)
+	}
+
+	return chainErr
+}
+
+func envHistory(args []string) error {
+	fs := flag.NewFlagSet("env history", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	showValues := fs.Bool("show-values", false, "print values instead of masking them")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env history KEY")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	history, err := services.PullEnvHistory(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	changes := services.KeyHistory(history, positional[0])
+	if len(changes) == 0 {
+		return fmt.Errorf("key %s never appeared in %s", positional[0], s.EnvName)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+
+		value := "********"
+		switch {
+		case change.Removed:
+			value = "(removed)"
+		case *showValues:
+			value = change.Value
+		}
+
+		fmt.Fprintf(tw, "v%d\t%s\t%s\t%s\t%s\n", change.Version, formatTime(change.Metadata.Timestamp), orUnknown(change.Metadata.Author), value, change.Metadata.Message)
+	}
+
+	return tw.Flush()
+}
+
+func envBlame(args []string) error {
+	fs := flag.NewFlagSet("env blame", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	history, err := services.PullEnvHistory(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, entry := range services.Blame(history) {
+		fmt.Fprintf(tw, "%s\tv%d\t%s\t%s\n", entry.Key, entry.Version, orUnknown(entry.Metadata.Author), formatTime(entry.Metadata.Timestamp))
+	}
+
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown date"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
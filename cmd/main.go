@@ -1,54 +1,155 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"os"
 
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
 	"github.com/envcrypts/envcrypt_cli/internal/services"
 )
 
+const usage = `usage: envcrypt <command> [flags]
+
+commands:
+  register                create an account and its keypair
+  project create NAME     create a project owned by the current user
+  project share EMAIL     give EMAIL access to -project, see -force
+  env set KEY=VALUE       set a single key and push a new version
+  env set KEY --stdin     read the value from stdin
+  env generate [KEY...]   set keys to random values, see -type, -length, -rotate and -print
+  env unset KEY           remove a key and push a new version
+  env expire KEY WHEN     set when a value expires (2025-12-31, 30d or never), or env set -expires
+  env expiring            list keys expiring -within 7d and fail if any expired
+  env describe [KEY...]   show what keys are for, or set -description, -owner, -tags, -runbook
+  env get KEY             print the value of a key
+  env pull                print the latest version in .env format, see -tag and -omit-expired
+  env edit                edit the latest version in $EDITOR
+  env log [-n N -page P]  list versions with metadata and changed keys
+  env history KEY         show every change to the value of a key
+  env blame               show the version that last changed each key
+  env stale               list keys overdue for rotation and fail if any, see -max-age and -within
+  env rollback VERSION    restore a version, see -dry-run, -keys and -force
+  env verify              check the hash chain and signatures, see -accept VERSION
+  env export -age RCPT    encrypt the latest version to age recipients, see -armor and -o
+  env import FILE         merge an age encrypted .env file, see -age-identity and -replace
+  env lint [FILE...]      check .env files for common mistakes, see -format json|github and -strict
+  run COMMAND [ARGS...]   run a command with the latest version in its environment, see -tag
+  sa create NAME          create a read-only service account for CI, see -envs
+  sa list                 list the service accounts of -project
+  sa rotate NAME          issue a new token and invalidate the previous one
+  sa revoke NAME          revoke a service account
+  trust list              list pinned keys of other users
+  trust show EMAIL        print the fingerprint and short code of a user
+  trust verify EMAIL      mark a key as verified after comparing it, or -ssh FILE
+  trust accept EMAIL      pin a changed key after confirming it
+  cache clear             remove all cached versions and keys
+  dev-server              run the in-memory reference server (-addr, -data)
+
+Commands that push a new version accept -m MESSAGE to describe the change.
+
+Common flags (also read from ENVCRYPT_SERVER, ENVCRYPT_EMAIL, ENVCRYPT_PROJECT
+and ENVCRYPT_ENV):
+  -server, -email, -project, -env
+
+-server takes an http(s) URL, or file:DIR or git:DIR to keep users, projects
+and encrypted versions in a local directory (git: commits every change).
+
+Everything read from a server is cached locally, still encrypted. When the
+server is unreachable, or with -offline (ENVCRYPT_OFFLINE=1), commands use
+the cache and warn about its age. Cached entries expire after 30 days.
+
+New keys and versions are encrypted with XChaCha20-Poly1305, set
+ENVCRYPT_AEAD=aes-256-gcm to use AES-256-GCM instead.
+
+Reading a version is refused when it is larger than 4MB decompressed, has
+more than 10000 keys, keys over 256 bytes or values over 256KB, and
+responses are limited to 256MB. ENVCRYPT_LIMITS changes any of them, e.g.
+env=8MB,keys=20000,key=512,value=1MB,response=512MB.
+
+env export -age takes age1... recipients or emails of envcrypt users, whose
+keys work as age keys: a file exported to your email is decrypted by env
+import without -age-identity, and trust show prints your age1... recipient.
+
+register -ssh-key ~/.ssh/id_ed25519 derives the account's keys from an
+OpenSSH ed25519 key instead of storing password protected ones; later
+commands need the same -ssh-key (ENVCRYPT_SSH_KEY) and read a passphrase
+from ENVCRYPT_SSH_PASSPHRASE or the terminal. Others can check such an
+account against its public SSH keys with trust verify EMAIL -ssh FILE.
+
+Every pull verifies the hash chain and signature of each version and fails
+if one was dropped, reordered or not signed by its author. Once the author
+confirms pushing a version reported as unsigned or unverified, env verify
+-accept VERSION stops reporting it on this machine.
+
+Pushes are checked against the nearest .envcrypt.schema file (YAML or JSON)
+in the working directory or its parents, and refused with a report per key
+if they break it, except for env rollback which only warns. ENVCRYPT_SCHEMA
+names another file, or "none" to skip.
+env generate without KEY fills in the keys that have a generate rule there,
+and env stale reads the max_age and owner rotation policies from it.
+
+The password is read from ENVCRYPT_PASSWORD or prompted for on the terminal.
+Without -email, commands that read a project log in with the service account
+token in ENVCRYPT_TOKEN instead.
+`
+
 func main() {
-	err := services.Register("vijayvenkaj@gmail.com", "vijayvenkatj")
-	if err != nil {
-		log.Println(err.Error())
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	keypair, userId, err := services.Login("vijayvenkaj@gmail.com", "vijayvenkatj")
-	if err != nil {
-		log.Fatal("Error Login:", err)
+	if name := os.Getenv("ENVCRYPT_AEAD"); name != "" {
+		aead, err := cryptutils.ParseAEAD(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: ENVCRYPT_AEAD:", err)
+			os.Exit(2)
+		}
+		cryptutils.DefaultAEAD = aead
 	}
 
-	err = services.CreateProject("dummyx", *userId, keypair.PublicKey)
-	if err != nil {
-		log.Println("Error project creation", err.Error())
+	if spec := os.Getenv("ENVCRYPT_LIMITS"); spec != "" {
+		limits, err := services.ParseLimits(spec, services.CurrentLimits())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: ENVCRYPT_LIMITS:", err)
+			os.Exit(2)
+		}
+		services.SetLimits(limits)
 	}
 
-	wrappedKeys, projectId, err := services.GetProject("dummyx", *userId)
-	if err != nil {
-		log.Println("Error wrappedKey get", err.Error())
+	var err error
+	switch os.Args[1] {
+	case "register":
+		err = runRegister(os.Args[2:])
+	case "project":
+		err = runProject(os.Args[2:])
+	case "env":
+		err = runEnv(os.Args[2:])
+	case "run":
+		err = runCommand(os.Args[2:])
+	case "sa":
+		err = runServiceAccount(os.Args[2:])
+	case "trust":
+		err = runTrust(os.Args[2:])
+	case "cache":
+		err = runCache(os.Args[2:])
+	case "dev-server":
+		err = runDevServer(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
 	}
 
-	//err = services.PushEnv(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, wrappedKeys)
-	//if err != nil {
-	//	log.Println("Error pushing env", err.Error())
-	//}
-	////
-	err = services.UpdateEnv(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, wrappedKeys)
-	if err != nil {
-		log.Println("Error updating env", err.Error())
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
 	}
-
-	//_, err = services.PullEnv(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, 5, wrappedKeys)
-	//if err != nil {
-	//	log.Println("Error pulling env", err.Error())
-	//}
-	//
-	//err = services.DiffENVVersions(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, wrappedKeys, 1, 3)
-
-	err = services.RollbackEnv(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, 1, wrappedKeys)
-
-	err = services.GetEnvVersions(*projectId, "vijayvenkaj@gmail.com", keypair.PrivateKey, wrappedKeys)
 	if err != nil {
-		log.Println("Error getting env versions", err.Error())
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
 	}
-
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func readPassword() (string, error) {
+	return readSecret("ENVCRYPT_PASSWORD", "Password: ")
+}
+
+// readSecret reads envVar, or prompts for it on the terminal without echo.
+func readSecret(envVar, prompt string) (string, error) {
+	if secret, ok := os.LookupEnv(envVar); ok {
+		return secret, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal available, set %s", envVar)
+	}
+	defer tty.Close()
+
+	restore, err := disableEcho(tty)
+	if err != nil {
+		return "", fmt.Errorf("%w, set %s", err, envVar)
+	}
+	fmt.Fprint(tty, prompt)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	restore()
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirm asks a yes/no question on the terminal and defaults to no.
+func confirm(question string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, errors.New("no terminal available to confirm")
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func envRollback(args []string) error {
+	fs := flag.NewFlagSet("env rollback", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "show the changes without pushing")
+	keys := fs.String("keys", "", "comma separated keys to restore, the rest keeps its latest value")
+	force := fs.Bool("force", false, "push even if nothing would change")
+	message := fs.String("m", "", "change message")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: env rollback VERSION")
+	}
+
+	version, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid version %q", positional[0])
+	}
+
+	var onlyKeys []string
+	for _, key := range strings.Split(*keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			onlyKeys = append(onlyKeys, key)
+		}
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if *dryRun {
+		plan, err := services.PlanRollback(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, int32(version), s.WrappedKey, onlyKeys)
+		if err != nil {
+			return err
+		}
+		if plan.Empty() {
+			fmt.Printf("Version %d matches the latest version %d, nothing to roll back.\n", plan.SourceVersion, plan.LatestVersion)
+			return nil
+		}
+
+		fmt.Printf("Rolling back to version %d would change version %d as follows:\n", plan.SourceVersion, plan.LatestVersion)
+		printMaskedDiff(os.Stdout, plan.Changes)
+		return nil
+	}
+
+	return services.RollbackEnv(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, int32(version), s.WrappedKey, services.RollbackOptions{
+		Keys:    onlyKeys,
+		Force:   *force,
+		Message: *message,
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// envPull prints the latest version in .env format. Expired values are
+// warned about on stderr.
+func envPull(args []string) error {
+	fs := flag.NewFlagSet("env pull", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	omitExpired := fs.Bool("omit-expired", false, "leave out expired values instead of only warning")
+	var tags listFlag
+	fs.Var(&tags, "tag", "only keys with this tag, repeatable")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errors.New("usage: env pull")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	env, err := pullLatest(s, *omitExpired, tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(cryptutils.NormalizeEnv(env))
+	return err
+}
+
+// runCommand runs a command with the latest version added to its
+// environment. Flags must come before the command.
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	omitExpired := fs.Bool("omit-expired", false, "leave out expired values instead of only warning")
+	var tags listFlag
+	fs.Var(&tags, "tag", "only keys with this tag, repeatable")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: run [flags] [--] COMMAND [ARGS...]")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	env, err := pullLatest(s, *omitExpired, tags)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	// The command's exit status becomes ours, once the deferred close has
+	// destroyed the keys.
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitStatus(exitErr.ExitCode())
+	}
+	return err
+}
+
+// exitStatus is returned to make main exit with that status without printing
+// an error.
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// pullLatest returns the latest values, only those tagged with one of tags
+// if any are given. Expired values are warned about on stderr, and left out
+// if omitExpired is set.
+func pullLatest(s *session, omitExpired bool, tags []string) (map[string]string, error) {
+	latest, err := services.PullLatestVersion(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return map[string]string{}, nil
+	}
+
+	env := latest.Env
+	if len(tags) > 0 {
+		env = services.WithTags(latest, env, tags)
+	}
+
+	env, expired := services.WithoutExpired(latest, env, time.Now(), omitExpired)
+	for _, k := range expired {
+		if omitExpired {
+			fmt.Fprintf(os.Stderr, "warning: left out %s, which expired %s\n", k.Key, formatTime(k.Expires))
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %s expired %s\n", k.Key, formatTime(k.Expires))
+		}
+	}
+
+	return env, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func runServiceAccount(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: sa create|list|rotate|revoke ...")
+	}
+
+	switch args[0] {
+	case "create":
+		return saCreate(args[1:])
+	case "list":
+		return saList(args[1:])
+	case "rotate":
+		return saRotate(args[1:])
+	case "revoke":
+		return saRevoke(args[1:])
+	default:
+		return fmt.Errorf("unknown sa command %q", args[0])
+	}
+}
+
+func saCreate(args []string) error {
+	fs := flag.NewFlagSet("sa create", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	envs := fs.String("envs", "", "comma separated envs the account may read (default -env)")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: sa create NAME [-envs A,B]")
+	}
+
+	envNames := []string{*sf.env}
+	if *envs != "" {
+		envNames = strings.Split(*envs, ",")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	token, err := services.CreateServiceAccount(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.WrappedKey, positional[0], envNames, *sf.server)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Created service account %s with read access to %s.\n", positional[0], strings.Join(envNames, ", "))
+	printToken(token)
+	return nil
+}
+
+func saList(args []string) error {
+	fs := flag.NewFlagSet("sa list", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	accounts, err := services.ListServiceAccounts(s.ProjectId, s.Email)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		fmt.Println("No service accounts.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENVS\tCREATED BY\tCREATED\tSTATUS")
+	for _, account := range accounts {
+		status := "active"
+		switch {
+		case account.Revoked():
+			status = "revoked " + formatTime(account.RevokedAt)
+		case !account.RotatedAt.IsZero():
+			status = "rotated " + formatTime(account.RotatedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", account.Name, strings.Join(account.Envs, ","), account.CreatedBy, formatTime(account.CreatedAt), status)
+	}
+	return w.Flush()
+}
+
+func saRotate(args []string) error {
+	fs := flag.NewFlagSet("sa rotate", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: sa rotate NAME")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	token, err := services.RotateServiceAccount(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.WrappedKey, positional[0], *sf.server)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Rotated service account %s, the previous token no longer works.\n", positional[0])
+	printToken(token)
+	return nil
+}
+
+func saRevoke(args []string) error {
+	fs := flag.NewFlagSet("sa revoke", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: sa revoke NAME")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if err := services.RevokeServiceAccount(s.ProjectId, s.Email, positional[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked service account %s.\n", positional[0])
+	return nil
+}
+
+// printToken writes the token alone to stdout so it can be piped into a CI
+// secret store, and the instructions to stderr.
+func printToken(token *services.ServiceToken) {
+	fmt.Fprintln(os.Stderr, "Store this token as the ENVCRYPT_TOKEN secret of your pipeline, it is not shown again:")
+	fmt.Println(token)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+	"github.com/google/uuid"
+)
+
+const defaultServer = "http://localhost:8080"
+
+type sessionFlags struct {
+	server  *string
+	email   *string
+	project *string
+	env     *string
+	offline *bool
+	sshKey  *string
+}
+
+func addSessionFlags(fs *flag.FlagSet) *sessionFlags {
+	return &sessionFlags{
+		server:  fs.String("server", envOr("ENVCRYPT_SERVER", defaultServer), "envcrypt server URL, or file:DIR / git:DIR to keep everything in a local directory"),
+		email:   fs.String("email", os.Getenv("ENVCRYPT_EMAIL"), "account email"),
+		project: fs.String("project", os.Getenv("ENVCRYPT_PROJECT"), "project name"),
+		env:     fs.String("env", envOr("ENVCRYPT_ENV", services.DefaultEnvName), "environment name"),
+		offline: fs.Bool("offline", os.Getenv("ENVCRYPT_OFFLINE") != "", "only use versions cached by earlier commands"),
+		sshKey:  fs.String("ssh-key", os.Getenv("ENVCRYPT_SSH_KEY"), "OpenSSH ed25519 private key of an account registered with one"),
+	}
+}
+
+// session holds everything needed to decrypt and push versions of a
+// single environment.
+type session struct {
+	Email      string
+	UserId     uuid.UUID
+	KeyPair    *cryptutils.KeyPair
+	ProjectId  uuid.UUID
+	WrappedKey *cryptutils.WrappedKey
+	EnvName    string
+}
+
+// connect points the services package at the selected backend. Servers
+// are reached through a local cache that is used when they are down.
+func (f *sessionFlags) connect() error {
+	backend, err := services.OpenBackend(*f.server)
+	if err != nil {
+		return err
+	}
+
+	if _, remote := backend.(*services.HTTPBackend); remote {
+		backend, err = services.NewCachingBackend(backend, *f.server, *f.offline)
+		if err != nil {
+			return err
+		}
+	}
+
+	services.DefaultBackend = backend
+	return nil
+}
+
+// login authenticates the user without selecting a project.
+func (f *sessionFlags) login() (*session, error) {
+	if *f.email == "" {
+		return nil, errors.New("missing -email")
+	}
+
+	if err := f.connect(); err != nil {
+		return nil, err
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	var keypair *cryptutils.KeyPair
+	var userId *uuid.UUID
+	if *f.sshKey != "" {
+		identity, err := loadSSHKey(*f.sshKey)
+		if err != nil {
+			return nil, err
+		}
+		keypair, userId, err = services.LoginSSH(*f.email, password, identity)
+		if err != nil {
+			identity.Destroy()
+			return nil, err
+		}
+	} else {
+		keypair, userId, err = services.Login(*f.email, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &session{
+		Email:   *f.email,
+		UserId:  *userId,
+		KeyPair: keypair,
+		EnvName: *f.env,
+	}, nil
+}
+
+// open logs in and selects the project. Without -email, a service account
+// token in ENVCRYPT_TOKEN is used instead.
+func (f *sessionFlags) open() (*session, error) {
+	if token := os.Getenv("ENVCRYPT_TOKEN"); token != "" && *f.email == "" {
+		return f.openService(token)
+	}
+
+	if *f.project == "" {
+		return nil, errors.New("missing -project")
+	}
+
+	s, err := f.login()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, projectId, err := services.GetProject(*f.project, s.UserId)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("project %s: %w", *f.project, err)
+	}
+
+	s.ProjectId = *projectId
+	s.WrappedKey = wrappedKey
+	return s, nil
+}
+
+func (f *sessionFlags) openService(encoded string) (*session, error) {
+	token, err := services.ParseServiceToken(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("ENVCRYPT_TOKEN: %w", err)
+	}
+
+	// The token remembers its server, an explicit -server still wins.
+	if token.Server != "" && *f.server == defaultServer && os.Getenv("ENVCRYPT_SERVER") == "" {
+		*f.server = token.Server
+	}
+	if err := f.connect(); err != nil {
+		return nil, err
+	}
+
+	account, wrappedKey, err := services.ServiceLogin(token)
+	if err != nil {
+		return nil, err
+	}
+	if *f.project != "" && *f.project != account.ProjectName {
+		return nil, fmt.Errorf("service account %s belongs to project %s, not %s", account.Name, account.ProjectName, *f.project)
+	}
+
+	envName := *f.env
+	if len(account.Envs) == 1 && envName == services.DefaultEnvName && os.Getenv("ENVCRYPT_ENV") == "" {
+		envName = account.Envs[0]
+	}
+	if !slices.Contains(account.Envs, envName) {
+		return nil, fmt.Errorf("service account %s can only read %s", account.Name, strings.Join(account.Envs, ", "))
+	}
+
+	publicKey, err := cryptutils.X25519PublicKey(token.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair := &cryptutils.KeyPair{PublicKey: publicKey}
+	keyPair.SetPrivateKeys(cryptutils.SecureBufferFrom(token.PrivateKey), nil)
+
+	return &session{
+		Email:      services.ServicePrincipal(account.Id),
+		UserId:     account.Id,
+		KeyPair:    keyPair,
+		ProjectId:  account.ProjectId,
+		WrappedKey: wrappedKey,
+		EnvName:    envName,
+	}, nil
+}
+
+// close zeroes the session's private keys.
+func (s *session) close() {
+	services.ForgetSigningKey(s.Email)
+	s.KeyPair.Destroy()
+}
+
+// loadSSHKey derives a keypair from an OpenSSH ed25519 private key, asking
+// for its passphrase if it has one.
+func loadSSHKey(path string) (*cryptutils.KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	keyPair, err := cryptutils.SSHKeyPair(data, func() ([]byte, error) {
+		passphrase, err := readSecret("ENVCRYPT_SSH_PASSPHRASE", "Passphrase for "+path+": ")
+		return []byte(passphrase), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return keyPair, nil
+}
+
+// sshPublicKeys returns the X25519 and Ed25519 public keys of the SSH key
+// at path, read from path.pub unless path already is a public key.
+func sshPublicKeys(path string) ([]byte, []byte, error) {
+	if !strings.HasSuffix(path, ".pub") {
+		if _, err := os.Stat(path + ".pub"); err != nil {
+			keyPair, err := loadSSHKey(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer keyPair.Destroy()
+
+			return keyPair.PublicKey, keyPair.SigningPublicKey, nil
+		}
+		path += ".pub"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, signingPublicKey, err := cryptutils.ParseSSHPublicKey(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return publicKey, signingPublicKey, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseArgs parses flags that may appear before, between or after
+// positional arguments and returns the positional ones.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+type staleResult struct {
+	Key       string    `json:"key"`
+	Status    string    `json:"status"`
+	AgeDays   int       `json:"age_days"`
+	MaxDays   int       `json:"max_age_days"`
+	Version   int32     `json:"version"`
+	ChangedAt time.Time `json:"changed_at,omitzero"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+}
+
+// envStale lists keys whose value is older than their rotation policy
+// allows, and fails if there are any so CI can gate on it.
+func envStale(args []string) error {
+	fs := flag.NewFlagSet("env stale", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	maxAge := fs.String("max-age", "", "max age of keys without one in the schema, e.g. 90d")
+	within := fs.String("within", "", "also list keys that become overdue within this period, e.g. 14d")
+	all := fs.Bool("all", false, "list every key with its age")
+	format := fs.String("format", "text", "output format: text or json")
+
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use text or json", *format)
+	}
+
+	var defaultMaxAge, soon time.Duration
+	var err error
+	if *maxAge != "" {
+		if defaultMaxAge, err = services.ParseDuration(*maxAge); err != nil {
+			return err
+		}
+	}
+	if *within != "" {
+		if soon, err = services.ParseDuration(*within); err != nil {
+			return err
+		}
+	}
+
+	schema, _, err := services.FindSchema()
+	if err != nil {
+		return err
+	}
+	if schema == nil && defaultMaxAge == 0 {
+		return errors.New("no rotation policy, set max_age in " + services.SchemaFileName + " or pass -max-age")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	history, err := services.PullEnvHistory(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	policy := func(key string) services.RotationPolicy {
+		var p services.RotationPolicy
+		if schema != nil {
+			p = schema.RotationPolicy(s.EnvName, key)
+		}
+		if p.MaxAge == 0 {
+			p.MaxAge = defaultMaxAge
+		}
+		if p.Owner == "" && len(history) > 0 {
+			p.Owner = history[len(history)-1].KeyInfo[key].Owner
+		}
+		return p
+	}
+
+	results := []staleResult{}
+	overdue := 0
+	for _, age := range services.KeyAges(history, time.Now(), policy) {
+		status := "ok"
+		switch {
+		case age.Policy.MaxAge == 0:
+			status = "no policy"
+		case age.Overdue(0):
+			status = "overdue"
+			overdue++
+		case age.Overdue(soon):
+			status = "due soon"
+		}
+		if !*all && status != "overdue" && status != "due soon" {
+			continue
+		}
+
+		results = append(results, staleResult{
+			Key:       age.Key,
+			Status:    status,
+			AgeDays:   int(age.Age / (24 * time.Hour)),
+			MaxDays:   int(age.Policy.MaxAge / (24 * time.Hour)),
+			Version:   age.Version,
+			ChangedAt: age.ChangedAt,
+			ChangedBy: age.ChangedBy,
+			Owner:     age.Policy.Owner,
+		})
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else if len(results) == 0 {
+		fmt.Printf("No keys of %s are due for rotation.\n", s.EnvName)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tSTATUS\tAGE\tMAX AGE\tCHANGED\tBY\tOWNER")
+		for _, r := range results {
+			age := fmt.Sprintf("%dd", r.AgeDays)
+			if r.ChangedAt.IsZero() {
+				age = "unknown"
+			}
+			maxDays, owner := "-", "-"
+			if r.MaxDays > 0 {
+				maxDays = fmt.Sprintf("%dd", r.MaxDays)
+			}
+			if r.Owner != "" {
+				owner = r.Owner
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\tv%d %s\t%s\t%s\n", r.Key, r.Status, age, maxDays, r.Version, formatTime(r.ChangedAt), orUnknown(r.ChangedBy), owner)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if overdue > 0 {
+		return fmt.Errorf("%d keys of %s are overdue for rotation", overdue, s.EnvName)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+func runTrust(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: trust list|show|verify|accept ...")
+	}
+
+	switch args[0] {
+	case "list":
+		return trustList(args[1:])
+	case "show":
+		return trustShow(args[1:])
+	case "verify":
+		return trustVerify(args[1:])
+	case "accept":
+		return trustAccept(args[1:])
+	default:
+		return fmt.Errorf("unknown trust command %q", args[0])
+	}
+}
+
+func trustList(args []string) error {
+	fs := flag.NewFlagSet("trust list", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	keys, err := services.KnownKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Println("No keys pinned yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tFINGERPRINT\tSTATUS\tFIRST SEEN")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.Email, key.Fingerprint(), trustStatus(key), formatTime(key.FirstSeen))
+	}
+	return w.Flush()
+}
+
+// trustShow prints the fingerprint of EMAIL and, for comparing over a call,
+// a short authentication string that both sides compute the same way.
+func trustShow(args []string) error {
+	fs := flag.NewFlagSet("trust show", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: trust show EMAIL")
+	}
+
+	s, err := sf.login()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	key, err := services.LookupUserKey(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("User:        %s\n", key.Email)
+	fmt.Printf("Fingerprint: %s\n", key.Fingerprint())
+	fmt.Printf("Status:      %s\n", trustStatus(key))
+	if recipient, err := cryptutils.AgeRecipient(key.PublicKey); err == nil {
+		fmt.Printf("Age:         %s\n", recipient)
+	}
+	if key.Email != s.Email {
+		sas := cryptutils.ShortAuthString(s.KeyPair.PublicKey, s.KeyPair.SigningPublicKey, key.PublicKey, key.SigningPublicKey)
+		fmt.Printf("Short code:  %s (%s should see the same code)\n", sas, key.Email)
+	}
+	return nil
+}
+
+func trustVerify(args []string) error {
+	fs := flag.NewFlagSet("trust verify", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	sshKeys := fs.String("ssh", "", "verify against the ssh-ed25519 keys in this authorized_keys file, e.g. from GitHub")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: trust verify EMAIL")
+	}
+
+	if err := sf.connect(); err != nil {
+		return err
+	}
+
+	key, err := services.LookupUserKey(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if *sshKeys != "" {
+		matches, err := matchesSSHKey(key, *sshKeys)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("the keys of %s are not derived from any ssh-ed25519 key in %s", key.Email, *sshKeys)
+		}
+	} else {
+		fmt.Printf("Fingerprint of %s: %s\n", key.Email, key.Fingerprint())
+		ok, err := confirm("Does it match the fingerprint " + key.Email + " reads out to you?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("not verified")
+		}
+	}
+
+	if _, err := services.VerifyUserKey(key.Email); err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %s.\n", key.Email)
+	return nil
+}
+
+// matchesSSHKey reports whether the keys of a user registered with an SSH
+// key derive from one of the keys in an authorized_keys file.
+func matchesSSHKey(key *services.KnownKey, path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		publicKey, signingPublicKey, err := cryptutils.ParseSSHPublicKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(publicKey, key.PublicKey) && bytes.Equal(signingPublicKey, key.SigningPublicKey) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func trustAccept(args []string) error {
+	fs := flag.NewFlagSet("trust accept", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: trust accept EMAIL")
+	}
+
+	if err := sf.connect(); err != nil {
+		return err
+	}
+
+	key, err := services.AcceptUserKey(positional[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pinned new key of %s: %s\n", key.Email, key.Fingerprint())
+	fmt.Printf("It is not verified yet, run `trust verify %s` after comparing it.\n", key.Email)
+	return nil
+}
+
+func trustStatus(key *services.KnownKey) string {
+	if key.Verified {
+		return "verified"
+	}
+	return "unverified"
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func disableEcho(tty *os.File) (func(), error) {
+	return nil, errors.New("cannot turn off terminal echo on this platform")
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// disableEcho turns off echo on tty and returns a function restoring it.
+// It fails rather than let a secret show up on the screen.
+func disableEcho(tty *os.File) (func(), error) {
+	fd := int(tty.Fd())
+
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, fmt.Errorf("cannot turn off terminal echo: %w", err)
+	}
+
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &noEcho); err != nil {
+		return nil, fmt.Errorf("cannot turn off terminal echo: %w", err)
+	}
+
+	return func() {
+		unix.IoctlSetTermios(fd, ioctlSetTermios, termios)
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// envVerify checks the hash chain and signatures of the history. -accept
+// acknowledges a version reported as unsigned or unverified, after its
+// author confirmed pushing it.
+func envVerify(args []string) error {
+	fs := flag.NewFlagSet("env verify", flag.ContinueOnError)
+	sf := addSessionFlags(fs)
+	accept := fs.Int("accept", 0, "accept the missing or unverifiable signature of this version")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errors.New("usage: env verify [-accept VERSION]")
+	}
+
+	s, err := sf.open()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if *accept != 0 {
+		if err := services.AcceptVersion(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, int32(*accept), s.WrappedKey); err != nil {
+			return err
+		}
+		fmt.Printf("Accepted version %d of %s.\n", *accept, s.EnvName)
+	}
+
+	history, err := services.PullEnvHistory(s.ProjectId, s.Email, s.KeyPair.PrivateKey, s.EnvName, s.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	signed := 0
+	for _, version := range history {
+		if version.Signed {
+			signed++
+		}
+	}
+	fmt.Printf("History of %s verified: %d versions, %d signed.\n", s.EnvName, len(history), signed)
+	return nil
+}
@@ -5,6 +5,5 @@ go 1.25.1
 require (
 	github.com/google/uuid v1.6.0
 	golang.org/x/crypto v0.46.0
+	golang.org/x/sys v0.39.0
 )
-
-require golang.org/x/sys v0.39.0 // indirect
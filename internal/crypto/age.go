@@ -0,0 +1,463 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Files in the age format (https://age-encryption.org/v1), so secrets can
+// be handed to people without an envcrypt account. Users' X25519 keys work
+// as age recipients and identities as they are. Only X25519 recipients are
+// supported, others found in a file are skipped.
+
+const (
+	ageVersionLine  = "age-encryption.org/v1"
+	ageX25519Label  = "age-encryption.org/v1/X25519"
+	ageRecipientHRP = "age"
+	ageIdentityHRP  = "AGE-SECRET-KEY-"
+
+	ageFileKeySize   = 16
+	ageNonceSize     = 16
+	ageChunkSize     = 64 * 1024
+	ageBodyLineWidth = 64
+
+	ageArmorBegin = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd   = "-----END AGE ENCRYPTED FILE-----"
+)
+
+var ErrAgeNoIdentity = errors.New("none of the identities can decrypt this age file")
+
+var ageBase64 = base64.RawStdEncoding.Strict()
+
+// AgeRecipient renders an X25519 public key as an age1... recipient.
+func AgeRecipient(publicKey []byte) (string, error) {
+	if len(publicKey) != 32 {
+		return "", errors.New("invalid public key length")
+	}
+	return bech32Encode(ageRecipientHRP, publicKey)
+}
+
+func ParseAgeRecipient(s string) ([]byte, error) {
+	hrp, key, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed age recipient %q: %w", s, err)
+	}
+	if hrp != ageRecipientHRP || len(key) != 32 {
+		return nil, fmt.Errorf("%q is not an X25519 age recipient", s)
+	}
+
+	return key, nil
+}
+
+// ParseAgeIdentities reads an identity file as written by age-keygen: one
+// AGE-SECRET-KEY-1... per line, blank lines and # comments ignored.
+func ParseAgeIdentities(data []byte) ([]*SecureBuffer, error) {
+	var identities []*SecureBuffer
+	destroy := func() {
+		for _, identity := range identities {
+			identity.Destroy()
+		}
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hrp, key, err := bech32Decode(line)
+		if err != nil || hrp != strings.ToLower(ageIdentityHRP) || len(key) != 32 {
+			zero(key)
+			destroy()
+			return nil, fmt.Errorf("line %d is not an X25519 age identity", i+1)
+		}
+		identities = append(identities, SecureBufferFrom(key))
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("no age identities found")
+	}
+
+	return identities, nil
+}
+
+// AgeEncrypt encrypts plaintext to X25519 recipients. With armor the result
+// is PEM-like text instead of binary.
+func AgeEncrypt(plaintext []byte, recipients [][]byte, armor bool) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no age recipients")
+	}
+
+	fileKey := make([]byte, ageFileKeySize)
+	defer zero(fileKey)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(ageVersionLine + "\n")
+	for _, recipient := range recipients {
+		share, body, err := ageWrapFileKey(fileKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		header.WriteString("-> X25519 " + ageBase64.EncodeToString(share) + "\n")
+		writeAgeBody(&header, body)
+	}
+	header.WriteString("---")
+
+	mac, err := ageHeaderMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header.WriteString(" " + ageBase64.EncodeToString(mac) + "\n")
+
+	nonce := make([]byte, ageNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header.Bytes(), nonce...)
+
+	payloadKey, err := ageHKDF(fileKey, nonce, "payload")
+	if err != nil {
+		return nil, err
+	}
+	defer zero(payloadKey)
+
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+	for counter, offset := uint64(0), 0; ; counter++ {
+		end := min(offset+ageChunkSize, len(plaintext))
+		last := end == len(plaintext)
+		out = aead.Seal(out, ageStreamNonce(counter, last), plaintext[offset:end], nil)
+		if last {
+			break
+		}
+		offset = end
+	}
+
+	if armor {
+		return ageArmor(out), nil
+	}
+	return out, nil
+}
+
+// AgeDecrypt decrypts an age file, binary or armored, with the first of the
+// X25519 identities that matches a recipient. Only envs are expected, so
+// plaintexts above EnvLimits.MaxEnvSize are refused.
+func AgeDecrypt(data []byte, identities [][]byte) (*SecureBuffer, error) {
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte(ageArmorBegin)) {
+		var err error
+		if data, err = ageDearmor(trimmed); err != nil {
+			return nil, err
+		}
+	}
+
+	stanzas, signed, mac, payload, err := parseAgeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	for _, identity := range identities {
+		if fileKey, err = ageUnwrapFileKey(stanzas, identity); err != nil {
+			return nil, err
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, ErrAgeNoIdentity
+	}
+	defer zero(fileKey)
+
+	expected, err := ageHeaderMAC(fileKey, signed)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expected) {
+		return nil, errors.New("age header has an invalid MAC")
+	}
+
+	return ageDecryptPayload(fileKey, payload)
+}
+
+type ageStanza struct {
+	args []string
+	body []byte
+}
+
+// parseAgeHeader splits an age file into its stanzas, the header bytes the
+// MAC covers, the MAC and the payload.
+func parseAgeHeader(data []byte) ([]ageStanza, []byte, []byte, []byte, error) {
+	malformed := errors.New("malformed age header")
+	rest := data
+
+	nextLine := func() (string, bool) {
+		line, after, found := bytes.Cut(rest, []byte("\n"))
+		if !found {
+			return "", false
+		}
+		rest = after
+		return string(line), true
+	}
+
+	if line, ok := nextLine(); !ok || line != ageVersionLine {
+		if strings.HasPrefix(line, "age-encryption.org/") {
+			return nil, nil, nil, nil, fmt.Errorf("unsupported age version %q", line)
+		}
+		return nil, nil, nil, nil, errors.New("not an age file")
+	}
+
+	var stanzas []ageStanza
+	for {
+		start := len(data) - len(rest)
+		line, ok := nextLine()
+		if !ok {
+			return nil, nil, nil, nil, malformed
+		}
+
+		if encoded, found := strings.CutPrefix(line, "--- "); found {
+			mac, err := ageBase64.DecodeString(encoded)
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, nil, malformed
+			}
+			return stanzas, data[:start+len("---")], mac, rest, nil
+		}
+
+		argLine, found := strings.CutPrefix(line, "-> ")
+		if !found {
+			return nil, nil, nil, nil, malformed
+		}
+		stanza := ageStanza{args: strings.Split(argLine, " ")}
+		for _, arg := range stanza.args {
+			if arg == "" || strings.IndexFunc(arg, func(r rune) bool { return r < 33 || r > 126 }) >= 0 {
+				return nil, nil, nil, nil, malformed
+			}
+		}
+
+		// The body ends with the first line shorter than a full one.
+		for {
+			line, ok := nextLine()
+			if !ok || len(line) > ageBodyLineWidth {
+				return nil, nil, nil, nil, malformed
+			}
+			chunk, err := ageBase64.DecodeString(line)
+			if err != nil {
+				return nil, nil, nil, nil, malformed
+			}
+			stanza.body = append(stanza.body, chunk...)
+			if len(line) < ageBodyLineWidth {
+				break
+			}
+		}
+		stanzas = append(stanzas, stanza)
+	}
+}
+
+func writeAgeBody(w *bytes.Buffer, body []byte) {
+	encoded := ageBase64.EncodeToString(body)
+	for len(encoded) >= ageBodyLineWidth {
+		w.WriteString(encoded[:ageBodyLineWidth] + "\n")
+		encoded = encoded[ageBodyLineWidth:]
+	}
+	w.WriteString(encoded + "\n")
+}
+
+// ageWrapFileKey returns the ephemeral share and the wrapped file key of an
+// X25519 stanza for recipient.
+func ageWrapFileKey(fileKey, recipient []byte) ([]byte, []byte, error) {
+	ephemeral, err := GenerateEphemeralKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(ephemeral.PrivateKey)
+
+	sharedSecret, err := X25519SharedSecret(ephemeral.PrivateKey, recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(sharedSecret)
+
+	wrapKey, err := ageHKDF(sharedSecret, append(bytes.Clone(ephemeral.PublicKey), recipient...), ageX25519Label)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(wrapKey)
+
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ephemeral.PublicKey, aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil), nil
+}
+
+// ageUnwrapFileKey returns the file key if identity can open one of the
+// X25519 stanzas, or nil if it cannot.
+func ageUnwrapFileKey(stanzas []ageStanza, identity []byte) ([]byte, error) {
+	publicKey, err := X25519PublicKey(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stanza := range stanzas {
+		if stanza.args[0] != "X25519" {
+			continue
+		}
+		if len(stanza.args) != 2 {
+			return nil, errors.New("malformed age X25519 stanza")
+		}
+		share, err := ageBase64.DecodeString(stanza.args[1])
+		if err != nil || len(share) != 32 || len(stanza.body) != ageFileKeySize+chacha20poly1305.Overhead {
+			return nil, errors.New("malformed age X25519 stanza")
+		}
+
+		sharedSecret, err := X25519SharedSecret(identity, share)
+		if err != nil {
+			return nil, err
+		}
+		wrapKey, err := ageHKDF(sharedSecret, append(share, publicKey...), ageX25519Label)
+		zero(sharedSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := chacha20poly1305.New(wrapKey)
+		zero(wrapKey)
+		if err != nil {
+			return nil, err
+		}
+		if fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza.body, nil); err == nil {
+			return fileKey, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func ageDecryptPayload(fileKey, payload []byte) (*SecureBuffer, error) {
+	if len(payload) < ageNonceSize {
+		return nil, errors.New("truncated age payload")
+	}
+	nonce, ciphertext := payload[:ageNonceSize], payload[ageNonceSize:]
+
+	// Every chunk but the last is full, the last is empty only if it is the
+	// only one.
+	const chunkOverhead = chacha20poly1305.Overhead
+	chunks := (len(ciphertext) + ageChunkSize + chunkOverhead - 1) / (ageChunkSize + chunkOverhead)
+	lastLen := len(ciphertext) - (chunks-1)*(ageChunkSize+chunkOverhead)
+	if chunks == 0 || lastLen < chunkOverhead || (lastLen == chunkOverhead && chunks > 1) {
+		return nil, errors.New("truncated age payload")
+	}
+	if err := checkEnvSize(len(ciphertext) - chunks*chunkOverhead); err != nil {
+		return nil, err
+	}
+
+	payloadKey, err := ageHKDF(fileKey, nonce, "payload")
+	if err != nil {
+		return nil, err
+	}
+	defer zero(payloadKey)
+
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := NewSecureBuffer(len(ciphertext) - chunks*chunkOverhead)
+	out := plaintext.Bytes()[:0]
+	for counter := range chunks {
+		chunk := ciphertext[:min(ageChunkSize+chunkOverhead, len(ciphertext))]
+		ciphertext = ciphertext[len(chunk):]
+
+		if out, err = aead.Open(out, ageStreamNonce(uint64(counter), counter == chunks-1), chunk, nil); err != nil {
+			plaintext.Destroy()
+			return nil, errors.New("age payload decryption failed")
+		}
+	}
+
+	return plaintext, nil
+}
+
+// ageStreamNonce is the 11 byte big-endian chunk counter followed by a flag
+// marking the last chunk.
+func ageStreamNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func ageHeaderMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := ageHKDF(fileKey, nil, "header")
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+func ageHKDF(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ageArmor(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var b bytes.Buffer
+	b.WriteString(ageArmorBegin + "\n")
+	for len(encoded) > 0 {
+		n := min(ageBodyLineWidth, len(encoded))
+		b.WriteString(encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	b.WriteString(ageArmorEnd + "\n")
+
+	return b.Bytes()
+}
+
+func ageDearmor(data []byte) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 2 || lines[0] != ageArmorBegin || lines[len(lines)-1] != ageArmorEnd {
+		return nil, errors.New("malformed armored age file")
+	}
+
+	// Every line is full but the last, which is not empty.
+	body := lines[1 : len(lines)-1]
+	for i, line := range body {
+		if line == "" || len(line) > ageBodyLineWidth || i < len(body)-1 && len(line) != ageBodyLineWidth {
+			return nil, errors.New("malformed armored age file")
+		}
+	}
+
+	decoded, err := base64.StdEncoding.Strict().DecodeString(strings.Join(body, ""))
+	if err != nil {
+		return nil, fmt.Errorf("malformed armored age file: %w", err)
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,219 @@
+package cryptutils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ageVector is a test vector of the age testkit in testdata/age: a header
+// of "key: value" lines, an empty line and the age file.
+type ageVector struct {
+	expect     string
+	payload    string
+	identities [][]byte
+	file       []byte
+}
+
+func readAgeVector(t *testing.T, path string) *ageVector {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &ageVector{}
+	r := bufio.NewReader(bytes.NewReader(data))
+	compressed := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal("vector ends in its header")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case "expect":
+			v.expect = value
+		case "payload":
+			v.payload = value
+		case "identity":
+			identities, err := ParseAgeIdentities([]byte(value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.identities = append(v.identities, identities[0].Bytes())
+		case "compressed":
+			compressed = value == "zlib"
+		}
+	}
+
+	var body io.Reader = r
+	if compressed {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = zr
+	}
+	if v.file, err = io.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestAgeTestkit(t *testing.T) {
+	// The stream vectors run into megabytes.
+	EnvLimits.MaxEnvSize = 64 << 20
+	t.Cleanup(func() { EnvLimits = DefaultLimits })
+
+	paths, err := filepath.Glob("testdata/age/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == "README" {
+			continue
+		}
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			v := readAgeVector(t, path)
+
+			plaintext, err := AgeDecrypt(v.file, v.identities)
+			if v.expect != "success" {
+				if err == nil {
+					plaintext.Destroy()
+					t.Fatalf("decrypted, want %s", v.expect)
+				}
+				if v.expect == "no match" && !errors.Is(err, ErrAgeNoIdentity) {
+					t.Errorf("got %v, want ErrAgeNoIdentity", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer plaintext.Destroy()
+			if sum := sha256.Sum256(plaintext.Bytes()); hex.EncodeToString(sum[:]) != v.payload {
+				t.Errorf("payload hash %x, want %s", sum, v.payload)
+			}
+		})
+	}
+}
+
+func ageKeyPair(t *testing.T) (identity, recipient []byte) {
+	t.Helper()
+	keyPair, err := GenerateEphemeralKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair.PrivateKey, keyPair.PublicKey
+}
+
+func TestAgeRoundTrip(t *testing.T) {
+	identity, recipient := ageKeyPair(t)
+	other, otherRecipient := ageKeyPair(t)
+
+	for _, size := range []int{0, 1, ageChunkSize - 1, ageChunkSize, ageChunkSize + 1, 2 * ageChunkSize, 2*ageChunkSize + 1} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		for _, armor := range []bool{false, true} {
+			file, err := AgeEncrypt(plaintext, [][]byte{otherRecipient, recipient}, armor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range [][]byte{identity, other} {
+				got, err := AgeDecrypt(file, [][]byte{id})
+				if err != nil {
+					t.Fatalf("%d bytes, armor %v: %v", size, armor, err)
+				}
+				if !bytes.Equal(got.Bytes(), plaintext) {
+					t.Errorf("%d bytes, armor %v: plaintext differs", size, armor)
+				}
+				got.Destroy()
+			}
+		}
+	}
+
+	stranger, _ := ageKeyPair(t)
+	file, err := AgeEncrypt([]byte("A=1\n"), [][]byte{recipient}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AgeDecrypt(file, [][]byte{stranger}); !errors.Is(err, ErrAgeNoIdentity) {
+		t.Errorf("decrypted with another identity: %v", err)
+	}
+}
+
+// ageChunks splits an age file into the part up to the payload nonce and its
+// encrypted chunks.
+func ageChunks(t *testing.T, file []byte) ([]byte, [][]byte) {
+	t.Helper()
+	i := bytes.Index(file, []byte("\n--- "))
+	if i < 0 {
+		t.Fatal("no header MAC")
+	}
+	i += bytes.IndexByte(file[i+1:], '\n') + 2 + ageNonceSize
+
+	var chunks [][]byte
+	for rest := file[i:]; len(rest) > 0; {
+		n := min(ageChunkSize+16, len(rest))
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	return file[:i], chunks
+}
+
+func TestAgeTamperedChunks(t *testing.T) {
+	identity, recipient := ageKeyPair(t)
+	plaintext := make([]byte, 2*ageChunkSize+100)
+	file, err := AgeEncrypt(plaintext, [][]byte{recipient}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, chunks := ageChunks(t, file)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+
+	join := func(chunks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{head}, chunks...), nil)
+	}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"last chunk dropped", join(chunks[0], chunks[1])},
+		{"last chunk truncated", join(chunks[0], chunks[1], chunks[2][:50])},
+		{"full chunks reordered", join(chunks[1], chunks[0], chunks[2])},
+		{"last chunk moved", join(chunks[0], chunks[2], chunks[1])},
+		{"chunk repeated", join(chunks[0], chunks[0], chunks[1], chunks[2])},
+		{"trailing data", append(join(chunks...), 0)},
+		{"payload dropped", head},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := AgeDecrypt(tt.file, [][]byte{identity}); err == nil {
+				got.Destroy()
+				t.Error("decrypted")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package cryptutils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 as specified in BIP 173, which age uses for its keys. Unlike BIP
+// 173 there is no limit on the length of a string.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// convertBits regroups data from frombits to tobits wide groups.
+func convertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<tobits - 1
+
+	out := make([]byte, 0, len(data)*int(frombits)/int(tobits)+1)
+	for _, b := range data {
+		if uint32(b)>>frombits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<frombits | uint32(b)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(tobits-bits)&maxv))
+		}
+	} else if bits >= frombits || acc<<(tobits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return out, nil
+}
+
+// bech32Encode encodes data under hrp. The result is lowercase unless hrp
+// is uppercase, in which case all of it is.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	lower := strings.ToLower(hrp)
+	if hrp != lower && hrp != strings.ToUpper(hrp) {
+		return "", errors.New("mixed case bech32 prefix")
+	}
+
+	polymod := bech32Polymod(append(append(bech32HRPExpand(lower), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var b strings.Builder
+	b.WriteString(lower)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := range 6 {
+		b.WriteByte(bech32Charset[polymod>>(5*(5-i))&31])
+	}
+
+	if hrp != lower {
+		return strings.ToUpper(b.String()), nil
+	}
+	return b.String(), nil
+}
+
+// bech32Decode returns the lowercased prefix and the data of s.
+func bech32Decode(s string) (string, []byte, error) {
+	hrp, values, err := bech32DecodeValues(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := convertBits(values, 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
+
+// bech32DecodeValues checks the checksum of s and returns its lowercased
+// prefix and its 5 bit values without the checksum.
+func bech32DecodeValues(s string) (string, []byte, error) {
+	lower := strings.ToLower(s)
+	if s != lower && s != strings.ToUpper(s) {
+		return "", nil, errors.New("mixed case bech32 string")
+	}
+
+	pos := strings.LastIndexByte(lower, '1')
+	if pos < 1 || pos+7 > len(lower) {
+		return "", nil, errors.New("malformed bech32 string")
+	}
+
+	hrp := lower[:pos]
+	for i := range len(hrp) {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character in bech32 prefix")
+		}
+	}
+
+	values := make([]byte, 0, len(lower)-pos-1)
+	for i := pos + 1; i < len(lower); i++ {
+		v := strings.IndexByte(bech32Charset, lower[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", lower[i])
+		}
+		values = append(values, byte(v))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+
+	return hrp, values[:len(values)-6], nil
+}
@@ -0,0 +1,90 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// Test vectors from BIP 173.
+
+func TestBech32ValidChecksums(t *testing.T) {
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	} {
+		hrp, values, err := bech32DecodeValues(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if want := strings.ToLower(s[:strings.LastIndexByte(s, '1')]); hrp != want {
+			t.Errorf("%s: prefix %q, want %q", s, hrp, want)
+		}
+
+		// Flipping a character breaks the checksum.
+		tampered := []byte(strings.ToLower(s))
+		i := len(hrp) + 1 + len(values)/2
+		tampered[i] = bech32Charset[(strings.IndexByte(bech32Charset, tampered[i])+1)%32]
+		if _, _, err := bech32DecodeValues(string(tampered)); err == nil {
+			t.Errorf("%s: accepted with %q", s, tampered)
+		}
+	}
+}
+
+func TestBech32InvalidChecksums(t *testing.T) {
+	// BIP 173 also rejects strings longer than 90 characters, which age
+	// identities need, so that vector is left out.
+	for _, s := range []string{
+		"\x201nwldj5",
+		"\x7f1axkwrx",
+		"\x801eym55h",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"de1lg7wt\xff",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+	} {
+		if hrp, values, err := bech32DecodeValues(s); err == nil {
+			t.Errorf("%q: decoded as %q %v", s, hrp, values)
+		}
+	}
+}
+
+func TestBech32RoundTrip(t *testing.T) {
+	for _, hrp := range []string{"age", "AGE-SECRET-KEY-"} {
+		for _, n := range []int{0, 1, 20, 32, 65} {
+			data := make([]byte, n)
+			rand.Read(data)
+
+			s, err := bech32Encode(hrp, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s != strings.ToLower(s) && s != strings.ToUpper(s) {
+				t.Errorf("%s: mixed case", s)
+			}
+
+			gotHRP, got, err := bech32Decode(s)
+			if err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+			if gotHRP != strings.ToLower(hrp) || !bytes.Equal(got, data) {
+				t.Errorf("%s: got %q %x, want %q %x", s, gotHRP, got, hrp, data)
+			}
+		}
+	}
+
+	if _, err := bech32Encode("Age", nil); err == nil {
+		t.Error("encoded a mixed case prefix")
+	}
+}
@@ -10,32 +10,66 @@ import (
 )
 
 func ParseEnv(data []byte) (map[string]string, error) {
-	envs := make(map[string]string)
+	if err := checkEnvSize(len(data)); err != nil {
+		return nil, err
+	}
 
-	lines := strings.Split(string(data), "\n")
+	envs := make(map[string]string)
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	// Work on data directly rather than a string copy of it, so callers can
+	// zero data and only the parsed values remain.
+	for len(data) > 0 {
+		var line []byte
+		line, data, _ = bytes.Cut(data, []byte("\n"))
+		line = bytes.TrimSpace(line)
 
 		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("#")) || bytes.HasPrefix(line, []byte("//")) {
 			continue
 		}
 
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
+		rawKey, rawVal, found := bytes.Cut(line, []byte("="))
+		if !found {
 			return nil, fmt.Errorf("invalid env line: %s", line)
 		}
 
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
+		key := string(bytes.TrimSpace(rawKey))
+		val := string(bytes.TrimSpace(rawVal))
+		if err := checkEntryLength(key, val); err != nil {
+			return nil, err
+		}
 
 		envs[key] = val
+		if err := checkKeyCount(len(envs)); err != nil {
+			return nil, err
+		}
 	}
 
 	return envs, nil
 }
 
+// ValidateEnvEntry reports whether key and value survive a round trip
+// through NormalizeEnv and ParseEnv unchanged.
+func ValidateEnvEntry(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("empty env key")
+	}
+	if err := checkEntryLength(key, value); err != nil {
+		return err
+	}
+	if strings.ContainsAny(key, "= \t\r\n") || strings.HasPrefix(key, "#") || strings.HasPrefix(key, "//") {
+		return fmt.Errorf("invalid env key: %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("env value for %s must not contain newlines", key)
+	}
+	if strings.TrimSpace(value) != value {
+		return fmt.Errorf("env value for %s must not have surrounding whitespace", key)
+	}
+
+	return nil
+}
+
 func NormalizeEnv(env map[string]string) []byte {
 	keys := make([]string, 0, len(env))
 	for k := range env {
@@ -75,7 +109,16 @@ func DecompressEnv(data []byte) ([]byte, error) {
 	}
 	defer gr.Close()
 
-	return io.ReadAll(gr)
+	// Read one byte past the limit to tell a full env from a bomb.
+	data, err = io.ReadAll(io.LimitReader(gr, int64(EnvLimits.MaxEnvSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEnvSize(len(data)); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
 
 func PrepareEnvForStorage(raw []byte) ([]byte, error) {
@@ -95,7 +138,15 @@ func PrepareEnvForStorage(raw []byte) ([]byte, error) {
 }
 
 func PrepareEnvForRollback(env map[string]string) ([]byte, error) {
+	if err := checkKeyCount(len(env)); err != nil {
+		return nil, err
+	}
+
+	// Refuse to push what could not be read back.
 	normalized := NormalizeEnv(env)
+	if err := checkEnvSize(len(normalized)); err != nil {
+		return nil, err
+	}
 
 	compressed, err := CompressEnv(normalized)
 	if err != nil {
@@ -105,11 +156,19 @@ func PrepareEnvForRollback(env map[string]string) ([]byte, error) {
 	return compressed, nil
 }
 
+// ReadEnvFromStorage parses a decrypted version, with or without an
+// EnvPayload header.
 func ReadEnvFromStorage(data []byte) (map[string]string, error) {
-	decompressed, err := DecompressEnv(data)
+	payload, err := DecodeEnvPayload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := DecompressEnv(payload.Env)
 	if err != nil {
 		return nil, err
 	}
+	defer zero(decompressed)
 
 	return ParseEnv(decompressed)
 }
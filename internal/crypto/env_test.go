@@ -0,0 +1,237 @@
+package cryptutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gzipBomb compresses far more than MaxEnvSize of a single repeated line
+// into a few kilobytes.
+func gzipBomb(t testing.TB) []byte {
+	t.Helper()
+	bomb, err := CompressEnv(bytes.Repeat([]byte("A=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\n"), 2*DefaultLimits.MaxEnvSize/32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bomb
+}
+
+func storedPayload(t testing.TB, env []byte, p EnvPayload) []byte {
+	t.Helper()
+	compressed, err := CompressEnv(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Env = compressed
+	data, err := EncodeEnvPayload(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// formatV3Payload stores env with info in format 3, which is only read
+// since format 4 replaced it.
+func formatV3Payload(t testing.TB, env []byte, info map[string]KeyInfo) []byte {
+	t.Helper()
+	data := storedPayload(t, env, EnvPayload{Version: 3})
+	data[len(payloadMagic)] = payloadFormatV3
+
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = binary.BigEndian.AppendUint32(data, uint32(len(encoded)))
+	return append(data, encoded...)
+}
+
+var (
+	longKey   = strings.Repeat("K", DefaultLimits.MaxKeyLength+1)
+	longValue = strings.Repeat("v", DefaultLimits.MaxValueLength+1)
+)
+
+func TestEnvLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		read func() error
+		want error
+	}{
+		{"gzip bomb", func() error { _, err := DecompressEnv(gzipBomb(t)); return err }, ErrEnvTooLarge},
+		{"gzip bomb in payload", func() error {
+			data, err := EncodeEnvPayload(&EnvPayload{EnvName: "Production", Version: 1, Env: gzipBomb(t)})
+			if err != nil {
+				return err
+			}
+			_, err = ReadEnvFromStorage(data)
+			return err
+		}, ErrEnvTooLarge},
+		{"long key", func() error { _, err := ParseEnv([]byte(longKey + "=1")); return err }, ErrKeyTooLong},
+		{"long value", func() error { _, err := ParseEnv([]byte("A=" + longValue)); return err }, ErrValueTooLong},
+		{"long value in payload", func() error {
+			_, err := ReadEnvFromStorage(storedPayload(t, []byte("A="+longValue), EnvPayload{Version: 1}))
+			return err
+		}, ErrValueTooLong},
+		{"too many keys", func() error {
+			var b strings.Builder
+			for i := range DefaultLimits.MaxKeys + 1 {
+				fmt.Fprintf(&b, "K%d=1\n", i)
+			}
+			_, err := ParseEnv([]byte(b.String()))
+			return err
+		}, ErrTooManyKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.read(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortKeyLimit(t *testing.T) {
+	EnvLimits.MaxKeyLength = 4
+	t.Cleanup(func() { EnvLimits = DefaultLimits })
+
+	for _, key := range []string{"ABCDE", strings.Repeat("K", 40)} {
+		if err := ValidateEnvEntry(key, "1"); !errors.Is(err, ErrKeyTooLong) {
+			t.Errorf("%s: got %v, want ErrKeyTooLong", key, err)
+		}
+	}
+}
+
+func FuzzParseEnv(f *testing.F) {
+	f.Add([]byte("A=1\nB = two \n# comment\n// comment\n\nC=x=y\r\n"))
+	f.Add([]byte("=empty key\nA=\n"))
+	f.Add([]byte("no equals sign"))
+	f.Add([]byte(longKey + "=1"))
+	f.Add([]byte("A=" + longValue))
+	f.Add(gzipBomb(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		env, err := ParseEnv(data)
+		if err != nil {
+			return
+		}
+		if len(env) > EnvLimits.MaxKeys {
+			t.Fatalf("parsed %d keys, limit is %d", len(env), EnvLimits.MaxKeys)
+		}
+		for key, value := range env {
+			if len(key) > EnvLimits.MaxKeyLength || len(value) > EnvLimits.MaxValueLength {
+				t.Fatalf("parsed an entry over the limits: %q", key)
+			}
+		}
+
+		again, err := ParseEnv(NormalizeEnv(env))
+		if err != nil {
+			t.Fatalf("normalized env does not parse: %v", err)
+		}
+		if !maps.Equal(env, again) {
+			t.Fatalf("normalizing changed the env: %q != %q", env, again)
+		}
+	})
+}
+
+func FuzzReadEnvFromStorage(f *testing.F) {
+	valid := []byte("A=1\nB=2\n")
+	legacy, err := CompressEnv(valid)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(legacy)
+	f.Add(storedPayload(f, valid, EnvPayload{EnvName: "Production", Version: 2, PrevHash: bytes.Repeat([]byte{1}, 32)}))
+	f.Add(storedPayload(f, valid, EnvPayload{Version: 3, Padding: PaddingPadme, KeyInfo: map[string]KeyInfo{"A": {Owner: "ops"}}}))
+	f.Add(formatV3Payload(f, valid, map[string]KeyInfo{"A": {Owner: "ops"}}))
+	f.Add(storedPayload(f, valid, EnvPayload{Version: 4, Keys: []string{"A"}, Generated: map[string]string{"A": "hex:32"}}))
+	f.Add(storedPayload(f, []byte("A="+longValue), EnvPayload{Version: 1}))
+	f.Add(storedPayload(f, []byte(longKey+"=1"), EnvPayload{Version: 1}))
+	f.Add(gzipBomb(f))
+	bomb, err := EncodeEnvPayload(&EnvPayload{EnvName: "Production", Version: 1, Env: gzipBomb(f)})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(bomb)
+	f.Add(bomb[:len(bomb)/2])
+	f.Add([]byte("ENVP"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		env, err := ReadEnvFromStorage(data)
+		if err != nil {
+			return
+		}
+		if len(env) > EnvLimits.MaxKeys {
+			t.Fatalf("read %d keys, limit is %d", len(env), EnvLimits.MaxKeys)
+		}
+		for key, value := range env {
+			if len(key) > EnvLimits.MaxKeyLength || len(value) > EnvLimits.MaxValueLength {
+				t.Fatalf("read an entry over the limits: %q", key)
+			}
+		}
+	})
+}
+
+func TestPayloadFormats(t *testing.T) {
+	info := map[string]KeyInfo{"A": {Owner: "ops"}}
+	tests := []struct {
+		name    string
+		data    []byte
+		format  byte
+		keyInfo map[string]KeyInfo
+	}{
+		{"plain", storedPayload(t, []byte("A=1"), EnvPayload{Version: 1}), payloadFormatV2, nil},
+		{"key info", storedPayload(t, []byte("A=1"), EnvPayload{Version: 1, KeyInfo: info}), payloadFormatV4, info},
+		{"timestamp", storedPayload(t, []byte("A=1"), EnvPayload{Version: 1, Timestamp: time.Now()}), payloadFormatV4, nil},
+		{"format 3", formatV3Payload(t, []byte("A=1"), info), payloadFormatV3, info},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.data[len(payloadMagic)]; got != tt.format {
+				t.Errorf("format %d, want %d", got, tt.format)
+			}
+			p, err := DecodeEnvPayload(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.KeyInfo, tt.keyInfo) {
+				t.Errorf("key info %v, want %v", p.KeyInfo, tt.keyInfo)
+			}
+		})
+	}
+}
+
+func TestKeyInfoValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		info KeyInfo
+		ok   bool
+	}{
+		{"plain", KeyInfo{Description: "Stripe key\nrotate monthly", Owner: "ops", Runbook: "https://wiki/stripe"}, true},
+		{"unicode", KeyInfo{Description: "clé de l'API ✓", Owner: "équipe"}, true},
+		{"escape in description", KeyInfo{Description: "\x1b[2Jcleared"}, false},
+		{"carriage return in description", KeyInfo{Description: "a\rb"}, false},
+		{"tab in owner", KeyInfo{Owner: "ops\tteam"}, false},
+		{"bell in runbook", KeyInfo{Runbook: "https://wiki/\x07"}, false},
+		{"C1 in owner", KeyInfo{Owner: "ops\u009b2J"}, false},
+		{"DEL in description", KeyInfo{Description: "a\x7fb"}, false},
+		{"newline in owner", KeyInfo{Owner: "ops\nteam"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.info.Validate(); (err == nil) != tt.ok {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Every blob sealed by this package starts with an envelope header naming
+// how it was produced:
+//
+//	magic "ENVC" | format | AEAD ID | KDF ID | compression ID | ciphertext
+//
+// The header is authenticated as additional data. Blobs written before the
+// header existed are bare AES-256-GCM ciphertexts and are still opened.
+
+type AEADID byte
+
+const (
+	AEADAES256GCM         AEADID = 1
+	AEADXChaCha20Poly1305 AEADID = 2
+)
+
+func (id AEADID) String() string {
+	switch id {
+	case AEADAES256GCM:
+		return "aes-256-gcm"
+	case AEADXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	default:
+		return fmt.Sprintf("aead(%d)", byte(id))
+	}
+}
+
+// ParseAEAD is the inverse of AEADID.String.
+func ParseAEAD(name string) (AEADID, error) {
+	for _, id := range []AEADID{AEADAES256GCM, AEADXChaCha20Poly1305} {
+		if strings.EqualFold(name, id.String()) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown AEAD %q", name)
+}
+
+// KDFID names how the key a blob is sealed with was derived.
+type KDFID byte
+
+const (
+	KDFNone       KDFID = 0 // the key is used as is, e.g. the PMK
+	KDFHKDFSHA256 KDFID = 1 // X25519 shared secret through HKDF-SHA256
+	KDFArgon2id   KDFID = 2 // password through Argon2id
+)
+
+// CompressionID names how the plaintext was compressed before sealing.
+type CompressionID byte
+
+const (
+	CompressionNone CompressionID = 0
+	CompressionGzip CompressionID = 1
+)
+
+// DefaultAEAD seals every new blob. XChaCha20-Poly1305 has 192-bit nonces,
+// which stay safe to pick at random however many versions are pushed.
+var DefaultAEAD = AEADXChaCha20Poly1305
+
+var envelopeMagic = []byte("ENVC")
+
+const (
+	envelopeFormatV1  = 1
+	envelopeHeaderLen = 8
+)
+
+type EnvelopeHeader struct {
+	AEAD        AEADID
+	KDF         KDFID
+	Compression CompressionID
+}
+
+func (h *EnvelopeHeader) marshal() []byte {
+	header := make([]byte, 0, envelopeHeaderLen)
+	header = append(header, envelopeMagic...)
+	return append(header, envelopeFormatV1, byte(h.AEAD), byte(h.KDF), byte(h.Compression))
+}
+
+// ParseEnvelopeHeader returns the header of blob, or nil for a blob sealed
+// before envelopes existed. Legacy ciphertexts are random and may start with
+// the magic by chance, so an unknown format after it is taken for one too.
+func ParseEnvelopeHeader(blob []byte) (*EnvelopeHeader, error) {
+	if !bytes.HasPrefix(blob, envelopeMagic) || len(blob) < envelopeHeaderLen {
+		return nil, nil
+	}
+	if blob[4] != envelopeFormatV1 {
+		return nil, nil
+	}
+
+	header := &EnvelopeHeader{
+		AEAD:        AEADID(blob[5]),
+		KDF:         KDFID(blob[6]),
+		Compression: CompressionID(blob[7]),
+	}
+	switch header.Compression {
+	case CompressionNone, CompressionGzip:
+	default:
+		return nil, fmt.Errorf("unsupported envelope compression %d", header.Compression)
+	}
+
+	return header, nil
+}
+
+func newAEAD(id AEADID, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case AEADAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AEADXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported AEAD %s", id)
+	}
+}
+
+// seal encrypts plaintext with DefaultAEAD under a fresh random nonce and
+// returns the envelope and the nonce.
+func seal(key []byte, kdf KDFID, compression CompressionID, plaintext []byte) ([]byte, []byte, error) {
+	header := &EnvelopeHeader{AEAD: DefaultAEAD, KDF: kdf, Compression: compression}
+
+	aead, err := newAEAD(header.AEAD, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	prefix := header.marshal()
+	return aead.Seal(prefix, nonce, plaintext, prefix), nonce, nil
+}
+
+var errDecrypt = errors.New("decryption failed")
+
+// open decrypts a blob produced by seal, or a bare AES-256-GCM ciphertext
+// from before envelopes existed, into a SecureBuffer. kdf must match the
+// header, so a blob cannot be opened in a context it was not sealed for.
+func open(key, blob, nonce []byte, kdf KDFID) (*SecureBuffer, error) {
+	header, headerErr := ParseEnvelopeHeader(blob)
+	if header != nil {
+		var plaintext *SecureBuffer
+		if plaintext, headerErr = openEnvelope(header, key, blob, nonce, kdf); headerErr == nil {
+			return plaintext, nil
+		}
+	}
+
+	// A legacy ciphertext may start with what looks like a header by
+	// chance, so whatever is wrong with it, try it as one.
+	aead, err := newAEAD(AEADAES256GCM, key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openInto(aead, blob, nonce, nil)
+	if err != nil && headerErr != nil {
+		return nil, headerErr
+	}
+	return plaintext, err
+}
+
+func openEnvelope(header *EnvelopeHeader, key, blob, nonce []byte, kdf KDFID) (*SecureBuffer, error) {
+	if header.KDF != kdf {
+		return nil, fmt.Errorf("envelope key derivation %d does not match %d", header.KDF, kdf)
+	}
+
+	aead, err := newAEAD(header.AEAD, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return openInto(aead, blob[envelopeHeaderLen:], nonce, blob[:envelopeHeaderLen])
+}
+
+// openInto decrypts straight into a SecureBuffer so the plaintext never
+// touches the heap.
+func openInto(aead cipher.AEAD, ciphertext, nonce, additionalData []byte) (*SecureBuffer, error) {
+	if len(nonce) != aead.NonceSize() || len(ciphertext) < aead.Overhead() {
+		return nil, errDecrypt
+	}
+
+	plaintext := NewSecureBuffer(len(ciphertext) - aead.Overhead())
+	if _, err := aead.Open(plaintext.Bytes()[:0], nonce, ciphertext, additionalData); err != nil {
+		plaintext.Destroy()
+		return nil, errDecrypt
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,78 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvelopeHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		blob   []byte
+		header *EnvelopeHeader
+		err    string
+	}{
+		{"legacy", []byte("not an envelope"), nil, ""},
+		{"short", []byte("ENVC\x01"), nil, ""},
+		{"v1", []byte("ENVC\x01\x02\x00\x01ciphertext"), &EnvelopeHeader{AEAD: AEADXChaCha20Poly1305, Compression: CompressionGzip}, ""},
+		{"unknown format", []byte("ENVC\x09\x02\x00\x01ciphertext"), nil, ""},
+		{"unknown compression", []byte("ENVC\x01\x02\x00\x07ciphertext"), nil, "unsupported envelope compression 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := ParseEnvelopeHeader(tt.blob)
+			switch {
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("got %v, want %q", err, tt.err)
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			}
+			if (header == nil) != (tt.header == nil) || header != nil && *header != *tt.header {
+				t.Errorf("got %+v, want %+v", header, tt.header)
+			}
+		})
+	}
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plaintext := []byte("A=1\n")
+
+	for _, aead := range []AEADID{AEADAES256GCM, AEADXChaCha20Poly1305} {
+		t.Run(aead.String(), func(t *testing.T) {
+			old := DefaultAEAD
+			t.Cleanup(func() { DefaultAEAD = old })
+			DefaultAEAD = aead
+
+			blob, nonce, err := seal(key, KDFNone, CompressionGzip, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opened, err := open(key, blob, nonce, KDFNone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer opened.Destroy()
+			if !bytes.Equal(opened.Bytes(), plaintext) {
+				t.Errorf("got %q, want %q", opened.Bytes(), plaintext)
+			}
+
+			if _, err := open(key, blob, nonce, KDFArgon2id); err == nil || !strings.Contains(err.Error(), "key derivation") {
+				t.Errorf("opened with another KDF: %v", err)
+			}
+
+			bad, _, err := seal(key, KDFNone, 7, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := open(key, bad, nonce, KDFNone); err == nil || !strings.Contains(err.Error(), "compression") {
+				t.Errorf("opened an unknown compression: %v", err)
+			}
+		})
+	}
+}
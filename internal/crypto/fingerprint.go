@@ -0,0 +1,55 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Fingerprint condenses a user's X25519 and Ed25519 public keys into 32 hex
+// digits in groups of four, short enough to read out over the phone.
+func Fingerprint(publicKey, signingPublicKey []byte) string {
+	sum := fingerprintHash(publicKey, signingPublicKey)
+	digits := hex.EncodeToString(sum[:16])
+
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+
+	return strings.Join(groups, " ")
+}
+
+// ShortAuthString derives a 9 digit code from the identities of two users.
+// Both compute the same code from their own view of the keys, so comparing
+// it out of band proves neither saw a substituted key.
+func ShortAuthString(publicKeyA, signingKeyA, publicKeyB, signingKeyB []byte) string {
+	a := fingerprintHash(publicKeyA, signingKeyA)
+	b := fingerprintHash(publicKeyB, signingKeyB)
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+
+	h := sha256.New()
+	h.Write([]byte("envcrypt-sas-v1"))
+	h.Write(a[:])
+	h.Write(b[:])
+	sum := h.Sum(nil)
+
+	code := binary.BigEndian.Uint32(sum) % 1_000_000_000
+	return fmt.Sprintf("%03d %03d %03d", code/1_000_000, code/1000%1000, code%1000)
+}
+
+func fingerprintHash(publicKey, signingPublicKey []byte) [sha256.Size]byte {
+	var msg []byte
+	msg = append(msg, "envcrypt-fingerprint-v1"...)
+	for _, key := range [][]byte{publicKey, signingPublicKey} {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(key)))
+		msg = append(msg, key...)
+	}
+
+	return sha256.Sum256(msg)
+}
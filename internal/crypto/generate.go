@@ -0,0 +1,121 @@
+package cryptutils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	alnumAlphabet   = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	passwordSymbols = "!%*+,-.:@^_~"
+)
+
+// SecretPolicy describes how to generate a value. Length counts characters
+// for password and alnum, and random bytes for hex and base64, the way
+// openssl rand does. uuid values are random (version 4) UUIDs.
+type SecretPolicy struct {
+	Type   string `json:"type"`
+	Length int    `json:"length,omitempty"`
+}
+
+var secretDefaults = map[string]struct{ length, min int }{
+	"password": {32, 12},
+	"alnum":    {32, 12},
+	"hex":      {32, 16},
+	"base64":   {32, 16},
+	"uuid":     {0, 0},
+}
+
+func (p SecretPolicy) String() string {
+	if p.Type == "uuid" {
+		return p.Type
+	}
+	return fmt.Sprintf("%s:%d", p.Type, p.withDefaults().Length)
+}
+
+func (p SecretPolicy) withDefaults() SecretPolicy {
+	if p.Length == 0 {
+		p.Length = secretDefaults[p.Type].length
+	}
+	return p
+}
+
+// Check reports policies that would generate weak or malformed values.
+func (p SecretPolicy) Check() error {
+	defaults, known := secretDefaults[p.Type]
+	switch {
+	case !known:
+		return fmt.Errorf("unknown secret type %q, use password, hex, base64, uuid or alnum", p.Type)
+	case p.Type == "uuid" && p.Length != 0:
+		return fmt.Errorf("uuid values have a fixed length")
+	case p.Length < 0, p.Length != 0 && p.Length < defaults.min:
+		unit := "characters"
+		if p.Type == "hex" || p.Type == "base64" {
+			unit = "bytes"
+		}
+		return fmt.Errorf("%s values need at least %d %s", p.Type, defaults.min, unit)
+	}
+	return nil
+}
+
+// GenerateSecret returns a new value drawn from crypto/rand.
+func GenerateSecret(policy SecretPolicy) (string, error) {
+	if err := policy.Check(); err != nil {
+		return "", err
+	}
+	policy = policy.withDefaults()
+
+	switch policy.Type {
+	case "password":
+		// Retry until every class is present, so the result passes
+		// composition rules without skewing the distribution.
+		for {
+			value, err := randomString(alnumAlphabet+passwordSymbols, policy.Length)
+			if err != nil {
+				return "", err
+			}
+			if strings.ContainsAny(value, alnumAlphabet[:26]) && strings.ContainsAny(value, alnumAlphabet[26:52]) &&
+				strings.ContainsAny(value, alnumAlphabet[52:]) && strings.ContainsAny(value, passwordSymbols) {
+				return value, nil
+			}
+		}
+	case "alnum":
+		return randomString(alnumAlphabet, policy.Length)
+	case "hex", "base64":
+		raw := make([]byte, policy.Length)
+		defer zero(raw)
+		if _, err := rand.Read(raw); err != nil {
+			return "", err
+		}
+		if policy.Type == "hex" {
+			return hex.EncodeToString(raw), nil
+		}
+		return base64.StdEncoding.EncodeToString(raw), nil
+	default:
+		id, err := uuid.NewRandomFromReader(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+}
+
+// randomString picks n characters uniformly from alphabet.
+func randomString(alphabet string, n int) (string, error) {
+	out := make([]byte, n)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range out {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out[i] = alphabet[index.Int64()]
+	}
+	return string(out), nil
+}
@@ -0,0 +1,57 @@
+package cryptutils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits bound what reading an env may allocate, so a malicious server or a
+// corrupted version cannot exhaust memory.
+type Limits struct {
+	// MaxEnvSize is the size of an env in .env form, after decompression.
+	MaxEnvSize     int
+	MaxKeys        int
+	MaxKeyLength   int
+	MaxValueLength int
+}
+
+var DefaultLimits = Limits{
+	MaxEnvSize:     4 << 20, // 4 MB
+	MaxKeys:        10000,
+	MaxKeyLength:   256,
+	MaxValueLength: 256 << 10, // 256 KB
+}
+
+// EnvLimits is enforced by ParseEnv, ValidateEnvEntry and DecompressEnv.
+var EnvLimits = DefaultLimits
+
+var (
+	ErrEnvTooLarge  = errors.New("env exceeds the size limit")
+	ErrTooManyKeys  = errors.New("env exceeds the key count limit")
+	ErrKeyTooLong   = errors.New("env key exceeds the length limit")
+	ErrValueTooLong = errors.New("env value exceeds the length limit")
+)
+
+func checkEnvSize(size int) error {
+	if size > EnvLimits.MaxEnvSize {
+		return fmt.Errorf("%w of %d bytes", ErrEnvTooLarge, EnvLimits.MaxEnvSize)
+	}
+	return nil
+}
+
+func checkKeyCount(count int) error {
+	if count > EnvLimits.MaxKeys {
+		return fmt.Errorf("%w of %d", ErrTooManyKeys, EnvLimits.MaxKeys)
+	}
+	return nil
+}
+
+func checkEntryLength(key, value string) error {
+	if len(key) > EnvLimits.MaxKeyLength {
+		return fmt.Errorf("%w of %d bytes: %s...", ErrKeyTooLong, EnvLimits.MaxKeyLength, key[:min(len(key), 32)])
+	}
+	if len(value) > EnvLimits.MaxValueLength {
+		return fmt.Errorf("%w of %d bytes: %s", ErrValueTooLong, EnvLimits.MaxValueLength, key)
+	}
+	return nil
+}
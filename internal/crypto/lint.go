@@ -0,0 +1,167 @@
+package cryptutils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue is a likely mistake in a .env file. Like schema problems, it
+// never includes the value.
+type LintIssue struct {
+	Line     int    `json:"line"`
+	Key      string `json:"key,omitempty"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// placeholderValues are compared case insensitively, after stripping
+// quotes.
+var placeholderValues = map[string]bool{
+	"changeme": true, "change_me": true, "change-me": true, "replaceme": true,
+	"todo": true, "fixme": true, "tbd": true, "xxx": true, "placeholder": true,
+	"secret": true, "password": true,
+}
+
+// osVariables are set by the shell or read by the loader and libc, and
+// would be overridden in every process started by run.
+var osVariables = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true,
+	"PWD": true, "OLDPWD": true, "TERM": true, "LANG": true, "TZ": true,
+	"TMPDIR": true, "TMP": true, "TEMP": true, "HOSTNAME": true, "IFS": true,
+	"PS1": true, "LD_PRELOAD": true, "LD_LIBRARY_PATH": true,
+	"DYLD_INSERT_LIBRARIES": true, "DYLD_LIBRARY_PATH": true,
+	"SYSTEMROOT": true, "USERPROFILE": true, "APPDATA": true, "COMSPEC": true,
+}
+
+// LintEnv reads data the way ParseEnv does and reports what ParseEnv would
+// silently drop, overwrite or keep by mistake.
+func LintEnv(data []byte) []LintIssue {
+	var issues []LintIssue
+	report := func(line int, key, rule, severity, format string, args ...any) {
+		issues = append(issues, LintIssue{line, key, rule, severity, fmt.Sprintf(format, args...)})
+	}
+
+	seen := make(map[string]int)
+	inPEM := false
+	for i, raw := range bytes.Split(data, []byte("\n")) {
+		number := i + 1
+		raw = bytes.TrimSuffix(raw, []byte("\r"))
+		line := bytes.TrimSpace(raw)
+
+		// The rest of a private key pasted over several lines is reported
+		// once, at its first line.
+		if inPEM || isPEMBoundary(line) {
+			if !inPEM {
+				report(number, "", "private-key", LintError, "plaintext private key spread over several lines, which ParseEnv cannot read")
+			}
+			inPEM = !bytes.HasPrefix(line, []byte("-----END"))
+			continue
+		}
+
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("#")) || bytes.HasPrefix(line, []byte("//")) {
+			continue
+		}
+
+		rawKey, rawVal, found := bytes.Cut(line, []byte("="))
+		if !found {
+			report(number, "", "invalid-line", LintError, "expected KEY=VALUE")
+			continue
+		}
+
+		key := string(bytes.TrimSpace(rawKey))
+		val := string(bytes.TrimSpace(rawVal))
+
+		switch {
+		case key == "":
+			report(number, "", "invalid-name", LintError, "empty key")
+		case strings.HasPrefix(key, "export "):
+			report(number, key, "invalid-name", LintError, "%q is not a valid name, the export prefix is kept as part of the key", key)
+		case !envKeyPattern.MatchString(key):
+			report(number, key, "invalid-name", LintError, "%q is not a valid name, use letters, digits and underscores", key)
+		}
+
+		if first, exists := seen[key]; exists {
+			report(number, key, "duplicate-key", LintError, "%s is already set on line %d and overwrites it", key, first)
+		} else {
+			seen[key] = number
+		}
+
+		if trimmed := bytes.TrimRight(raw, " \t"); len(trimmed) != len(raw) && len(bytes.TrimSpace(rawVal)) > 0 {
+			report(number, key, "trailing-whitespace", LintWarning, "trailing whitespace after the value of %s is dropped", key)
+		}
+
+		unquoted, balanced := unquoteEnvValue(val)
+		if !balanced {
+			report(number, key, "unbalanced-quotes", LintError, "unbalanced quotes in the value of %s", key)
+		}
+
+		switch {
+		case unquoted == "":
+			report(number, key, "placeholder", LintWarning, "%s is empty", key)
+		case isPlaceholder(unquoted):
+			report(number, key, "placeholder", LintWarning, "%s looks like a placeholder", key)
+		}
+
+		if osVariables[strings.ToUpper(key)] {
+			report(number, key, "shadows-os", LintWarning, "%s shadows an operating system variable", key)
+		}
+
+		if isPrivateKey(unquoted) {
+			report(number, key, "private-key", LintError, "%s looks like a plaintext private key", key)
+			if strings.Contains(unquoted, "-----BEGIN") && !strings.Contains(unquoted, "-----END") {
+				inPEM = true
+			}
+		}
+	}
+
+	return issues
+}
+
+// unquoteEnvValue strips matching quotes around value, and reports whether
+// its quotes are balanced. ParseEnv keeps quotes as part of the value.
+func unquoteEnvValue(value string) (string, bool) {
+	if value == "" {
+		return value, true
+	}
+	first, last := value[0], value[len(value)-1]
+	opens := first == '"' || first == '\''
+	closes := last == '"' || last == '\''
+	switch {
+	case opens && len(value) >= 2 && last == first:
+		return value[1 : len(value)-1], true
+	case opens || closes:
+		return value, false
+	}
+	return value, true
+}
+
+func isPlaceholder(value string) bool {
+	lower := strings.ToLower(value)
+	if placeholderValues[lower] {
+		return true
+	}
+	// <your-token>, ${TOKEN}, your_api_key_here and the like.
+	return strings.HasPrefix(lower, "<") && strings.HasSuffix(lower, ">") ||
+		strings.HasPrefix(lower, "${") && strings.HasSuffix(lower, "}") ||
+		strings.HasPrefix(lower, "your") && strings.HasSuffix(lower, "here")
+}
+
+func isPrivateKey(value string) bool {
+	return strings.Contains(value, "-----BEGIN") && strings.Contains(value, "PRIVATE KEY") ||
+		strings.HasPrefix(value, "AGE-SECRET-KEY-1") ||
+		strings.HasPrefix(value, "PuTTY-User-Key-File-")
+}
+
+func isPEMBoundary(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("-----BEGIN")) && bytes.Contains(line, []byte("PRIVATE KEY"))
+}
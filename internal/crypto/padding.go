@@ -0,0 +1,67 @@
+package cryptutils
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// PaddingScheme decides how far a payload is padded before encryption, so
+// the ciphertext length only leaks which bucket the env falls into.
+type PaddingScheme byte
+
+const (
+	PaddingNone PaddingScheme = iota
+	// PaddingPadme pads to Padmé buckets, which cost at most 12% overhead
+	// and leak O(log log n) bits of the length.
+	PaddingPadme
+	// PaddingPowerOfTwo pads to the next power of two, leaking O(log log n)
+	// bits at up to 100% overhead.
+	PaddingPowerOfTwo
+)
+
+// DefaultPadding is used for every version pushed.
+var DefaultPadding = PaddingPadme
+
+func (s PaddingScheme) String() string {
+	switch s {
+	case PaddingNone:
+		return "none"
+	case PaddingPadme:
+		return "padme"
+	case PaddingPowerOfTwo:
+		return "pow2"
+	default:
+		return fmt.Sprintf("padding(%d)", byte(s))
+	}
+}
+
+// PaddedLength returns the length n is padded to.
+func (s PaddingScheme) PaddedLength(n int) (int, error) {
+	switch s {
+	case PaddingNone:
+		return n, nil
+	case PaddingPadme:
+		return padme(n), nil
+	case PaddingPowerOfTwo:
+		if n <= 1 {
+			return n, nil
+		}
+		return 1 << bits.Len(uint(n-1)), nil
+	default:
+		return 0, fmt.Errorf("unknown padding scheme %d", byte(s))
+	}
+}
+
+// padme implements Padmé from "Reducing Metadata Leakage from Encrypted
+// Files and Communication with PURBs" (Nikitin et al., 2019).
+func padme(n int) int {
+	if n < 2 {
+		return n
+	}
+
+	e := bits.Len(uint(n)) - 1 // floor(log2 n)
+	s := bits.Len(uint(e))     // floor(log2 e) + 1
+	mask := (1 << (e - s)) - 1
+
+	return (n + mask) &^ mask
+}
@@ -0,0 +1,295 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var payloadMagic = []byte("ENVP")
+
+const (
+	payloadFormatV1 = 1
+	// payloadFormatV2 adds a padding scheme and the length of Env, which is
+	// followed by zero bytes until the whole payload has the padded length.
+	payloadFormatV2 = 2
+	// payloadFormatV3 adds KeyInfo after Env. It is no longer written but
+	// still read.
+	payloadFormatV3 = 3
+	// payloadFormatV4 replaces the KeyInfo of format 3 with payloadDetails,
+	// which also holds what the metadata of older versions kept in plaintext
+	// and when the version was pushed. As every push records its timestamp,
+	// every version pushed since is in this format and clients that only
+	// know format 3 cannot read them.
+	payloadFormatV4 = 4
+)
+
+// KeyInfo is what a version records about a key besides its value. It is
+// encrypted along with the values.
+type KeyInfo struct {
+	// Expires is when the value stops being valid, zero for never.
+	Expires time.Time `json:"expires,omitzero"`
+
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Runbook links to how the value is rotated or what breaks without it.
+	Runbook string `json:"runbook,omitempty"`
+}
+
+func (k KeyInfo) IsZero() bool {
+	return k.Expires.IsZero() && k.Description == "" && k.Owner == "" && len(k.Tags) == 0 && k.Runbook == ""
+}
+
+func (k KeyInfo) HasTag(tag string) bool {
+	return slices.Contains(k.Tags, tag)
+}
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]*$`)
+
+// Validate keeps key info small and printable on one line, except for the
+// description. Control characters, which could rewrite the terminal of
+// whoever prints the info, are refused.
+func (k KeyInfo) Validate() error {
+	switch {
+	case len(k.Description) > 1024:
+		return errors.New("description is longer than 1024 bytes")
+	case strings.ContainsAny(k.Owner+k.Runbook, "\r\n"):
+		return errors.New("owner and runbook must be a single line")
+	case hasControl(strings.ReplaceAll(k.Description, "\n", "")):
+		return errors.New("description contains control characters")
+	case hasControl(k.Owner + k.Runbook):
+		return errors.New("owner and runbook contain control characters")
+	case len(k.Tags) > 32:
+		return errors.New("more than 32 tags")
+	}
+	for _, tag := range k.Tags {
+		if len(tag) > 64 || !tagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q, use letters, digits and _.:/-", tag)
+		}
+	}
+	if k.Runbook != "" {
+		if u, err := url.Parse(k.Runbook); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("runbook %q is not an absolute URL", k.Runbook)
+		}
+	}
+	return nil
+}
+
+// hasControl reports whether s holds a C0 or C1 control character or DEL.
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// EnvPayload is the plaintext sealed into every env version. Besides the
+// compressed env it binds the version to its position in the history, so a
+// server cannot reorder, relabel or replay versions without the client
+// noticing.
+type EnvPayload struct {
+	EnvName  string
+	Version  int32
+	PrevHash []byte // VersionHash of the previous version, nil for the first
+	Env      []byte // output of PrepareEnvForStorage
+	KeyInfo  map[string]KeyInfo
+
+	// Keys names the keys the version changed and Generated maps the keys
+	// it generated to their policy, see services.Metadata. They are kept
+	// here so the server does not learn key names.
+	Keys      []string
+	Generated map[string]string
+
+	// Timestamp is when the version was pushed. Unlike the timestamp in
+	// the metadata the server cannot change it.
+	Timestamp time.Time
+
+	// Padding applied by EncodeEnvPayload. Payloads decoded from format 1
+	// or from before payloads carried a header are unpadded.
+	Padding PaddingScheme
+
+	// Chained is false for versions pushed before payloads carried a header,
+	// such versions only contain Env.
+	Chained bool
+}
+
+// payloadDetails is the JSON that follows Env in format 4.
+type payloadDetails struct {
+	KeyInfo   map[string]KeyInfo `json:"key_info,omitempty"`
+	Keys      []string           `json:"keys,omitempty"`
+	Generated map[string]string  `json:"generated,omitempty"`
+	Timestamp time.Time          `json:"timestamp,omitzero"`
+}
+
+func EncodeEnvPayload(p *EnvPayload) ([]byte, error) {
+	if len(p.EnvName) > 0xffff {
+		return nil, errors.New("env name too long")
+	}
+	if len(p.PrevHash) > 0xff {
+		return nil, errors.New("previous hash too long")
+	}
+
+	format := byte(payloadFormatV2)
+	var info []byte
+	var err error
+	if len(p.KeyInfo) > 0 || len(p.Keys) > 0 || len(p.Generated) > 0 || !p.Timestamp.IsZero() {
+		info, err = json.Marshal(payloadDetails{KeyInfo: p.KeyInfo, Keys: p.Keys, Generated: p.Generated, Timestamp: p.Timestamp})
+		if err != nil {
+			return nil, err
+		}
+		format = payloadFormatV4
+	}
+
+	var buf bytes.Buffer
+	buf.Write(payloadMagic)
+	buf.WriteByte(format)
+	binary.Write(&buf, binary.BigEndian, p.Version)
+	binary.Write(&buf, binary.BigEndian, uint16(len(p.EnvName)))
+	buf.WriteString(p.EnvName)
+	buf.WriteByte(byte(len(p.PrevHash)))
+	buf.Write(p.PrevHash)
+	buf.WriteByte(byte(p.Padding))
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Env)))
+	buf.Write(p.Env)
+	if format >= payloadFormatV3 {
+		binary.Write(&buf, binary.BigEndian, uint32(len(info)))
+		buf.Write(info)
+	}
+
+	padded, err := p.Padding.PaddedLength(buf.Len())
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(make([]byte, padded-buf.Len()))
+
+	return buf.Bytes(), nil
+}
+
+func DecodeEnvPayload(data []byte) (*EnvPayload, error) {
+	if !bytes.HasPrefix(data, payloadMagic) {
+		if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			return &EnvPayload{Env: data}, nil
+		}
+		return nil, errors.New("unknown env payload format")
+	}
+
+	r := bytes.NewReader(data[len(payloadMagic):])
+
+	format, err := r.ReadByte()
+	if err != nil {
+		return nil, errTruncatedPayload
+	}
+	if format < payloadFormatV1 || format > payloadFormatV4 {
+		return nil, fmt.Errorf("unsupported env payload format %d", format)
+	}
+
+	p := &EnvPayload{Chained: true}
+	if err := binary.Read(r, binary.BigEndian, &p.Version); err != nil {
+		return nil, errTruncatedPayload
+	}
+
+	var nameLen uint16
+	if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+		return nil, errTruncatedPayload
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, errTruncatedPayload
+	}
+	p.EnvName = string(name)
+
+	hashLen, err := r.ReadByte()
+	if err != nil {
+		return nil, errTruncatedPayload
+	}
+	if hashLen > 0 {
+		p.PrevHash = make([]byte, hashLen)
+		if _, err := io.ReadFull(r, p.PrevHash); err != nil {
+			return nil, errTruncatedPayload
+		}
+	}
+
+	rest := data[len(data)-r.Len():]
+	if format == payloadFormatV1 {
+		p.Env = rest
+		return p, nil
+	}
+
+	if len(rest) < 5 {
+		return nil, errTruncatedPayload
+	}
+	p.Padding = PaddingScheme(rest[0])
+	envLen := binary.BigEndian.Uint32(rest[1:5])
+	rest = rest[5:]
+	if uint64(envLen) > uint64(len(rest)) {
+		return nil, errTruncatedPayload
+	}
+	p.Env = rest[:envLen]
+	rest = rest[envLen:]
+
+	if format >= payloadFormatV3 {
+		if len(rest) < 4 {
+			return nil, errTruncatedPayload
+		}
+		infoLen := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint64(infoLen) > uint64(len(rest)) {
+			return nil, errTruncatedPayload
+		}
+		if format == payloadFormatV3 {
+			if err := json.Unmarshal(rest[:infoLen], &p.KeyInfo); err != nil {
+				return nil, fmt.Errorf("malformed key info: %w", err)
+			}
+		} else {
+			var details payloadDetails
+			if err := json.Unmarshal(rest[:infoLen], &details); err != nil {
+				return nil, fmt.Errorf("malformed version details: %w", err)
+			}
+			p.KeyInfo, p.Keys, p.Generated, p.Timestamp = details.KeyInfo, details.Keys, details.Generated, details.Timestamp
+		}
+		rest = rest[infoLen:]
+	}
+
+	// Padding must be zeros and exactly as long as the scheme demands, so
+	// no data can hide in it.
+	padded, err := p.Padding.PaddedLength(len(data) - len(rest))
+	if err != nil {
+		return nil, err
+	}
+	if padded != len(data) || !allZero(rest) {
+		return nil, errors.New("malformed env payload padding")
+	}
+
+	return p, nil
+}
+
+var errTruncatedPayload = errors.New("truncated env payload")
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionHash identifies a stored version by its nonce and ciphertext. The
+// next version commits to it through EnvPayload.PrevHash.
+func VersionHash(cipherText, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("envcrypt-chain-v1"))
+	binary.Write(h, binary.BigEndian, uint32(len(nonce)))
+	h.Write(nonce)
+	h.Write(cipherText)
+
+	return h.Sum(nil)
+}
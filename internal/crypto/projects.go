@@ -1,8 +1,6 @@
 package cryptutils
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/sha256"
@@ -31,6 +29,16 @@ func GenerateEphemeralKeyPair() (*EphemeralKeyPair, error) {
 	}, nil
 }
 
+// X25519PublicKey returns the public key belonging to privateKeyBytes.
+func X25519PublicKey(privateKeyBytes []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv.PublicKey().Bytes(), nil
+}
+
 func X25519SharedSecret(
 	privateKeyBytes []byte,
 	peerPublicKeyBytes []byte,
@@ -76,16 +84,26 @@ func DeriveWrapKey(sharedSecret []byte) ([]byte, error) {
 }
 
 type WrappedKey struct {
-	WrappedPMK       []byte `json:"wrapped_pmk"`        // AES-GCM ciphertext
-	WrapNonce        []byte `json:"wrap_nonce"`         // 12 bytes
+	WrappedPMK       []byte `json:"wrapped_pmk"`        // envelope, see seal
+	WrapNonce        []byte `json:"wrap_nonce"`         // 12 or 24 bytes
 	WrapEphemeralPub []byte `json:"wrap_ephemeral_pub"` // 32 bytes
 }
 
+var ErrUnverifiedRecipient = errors.New("recipient public key has not been verified")
+
+// WrapPMKForUser wraps pmk to a user's X25519 public key. Callers must pass
+// verified only for the user's own key or a key whose fingerprint was
+// compared out of band (or when the user explicitly forced it), otherwise a
+// server could substitute its own key and learn the PMK.
 func WrapPMKForUser(
 	pmk []byte,
 	recipientUserPublicKey []byte,
+	verified bool,
 ) (*WrappedKey, error) {
 
+	if !verified {
+		return nil, ErrUnverifiedRecipient
+	}
 	if len(pmk) != 32 {
 		return nil, errors.New("invalid PMK length")
 	}
@@ -109,30 +127,21 @@ func WrapPMKForUser(
 		return nil, err
 	}
 
+	defer zero(sharedSecret)
+
 	// 3. Derive symmetric wrap key via HKDF
 	wrapKey, err := DeriveWrapKey(sharedSecret)
 	if err != nil {
 		return nil, err
 	}
+	defer zero(wrapKey)
 
-	// 4. Encrypt PMK using AES-256-GCM
-	block, err := aes.NewCipher(wrapKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	// 4. Encrypt PMK
+	wrappedPMK, nonce, err := seal(wrapKey, KDFHKDFSHA256, CompressionNone, pmk)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, err
-	}
-
-	wrappedPMK := gcm.Seal(nil, nonce, pmk, nil)
-
 	return &WrappedKey{
 		WrappedPMK:       wrappedPMK,
 		WrapNonce:        nonce,
@@ -140,10 +149,12 @@ func WrapPMKForUser(
 	}, nil
 }
 
+// UnwrapPMK decrypts the PMK wrapped for userPrivateKey. The caller must
+// Destroy it.
 func UnwrapPMK(
 	wrapped *WrappedKey,
 	userPrivateKey []byte,
-) ([]byte, error) {
+) (*SecureBuffer, error) {
 
 	if len(userPrivateKey) != 32 {
 		return nil, errors.New("invalid user private key length")
@@ -170,68 +181,26 @@ func UnwrapPMK(
 		return nil, err
 	}
 
+	defer zero(sharedSecret)
+
 	// 2. Derive wrap key
 	wrapKey, err := DeriveWrapKey(sharedSecret)
 	if err != nil {
 		return nil, err
 	}
+	defer zero(wrapKey)
 
 	// 3. Decrypt PMK
-	block, err := aes.NewCipher(wrapKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	pmk, err := gcm.Open(
-		nil,
-		wrapped.WrapNonce,
-		wrapped.WrappedPMK,
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return pmk, nil
+	return open(wrapKey, wrapped.WrappedPMK, wrapped.WrapNonce, KDFHKDFSHA256)
 }
 
+// EncryptENV seals a payload with the PMK and returns the ciphertext and
+// nonce.
 func EncryptENV(pmk []byte, data []byte) ([]byte, []byte, error) {
-	block, err := aes.NewCipher(pmk)
-	if err != nil {
-		return nil, nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	rand.Read(nonce)
-
-	return gcm.Seal(nil, nonce, data, nil), nonce, nil
-}
-
-func DecryptENV(pmk []byte, encryptedData []byte, nonce []byte) ([]byte, error) {
-
-	block, err := aes.NewCipher(pmk)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	return gcm.Open(nil, nonce, encryptedData, nil)
+	return seal(pmk, KDFNone, CompressionGzip, data)
 }
 
-func zero(b []byte) {
-	for i := range b {
-		b[i] = 0
-	}
+// DecryptENV returns the decrypted payload, which the caller must Destroy.
+func DecryptENV(pmk []byte, encryptedData []byte, nonce []byte) (*SecureBuffer, error) {
+	return open(pmk, encryptedData, nonce, KDFNone)
 }
@@ -1,8 +1,6 @@
 package cryptutils
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/ecdh"
 	"crypto/rand"
 	"errors"
@@ -28,14 +26,46 @@ type KeyPair struct {
 	PublicKey  []byte              `json:"public_key"`
 	PrivateKey []byte              `json:"private_key"`
 	EncKey     EncryptedPrivateKey `json:"encrypted_private_key"`
+
+	// Ed25519 key used to sign pushed versions. The private half is stored
+	// as a seed and encrypted exactly like the X25519 private key.
+	SigningPublicKey  []byte              `json:"signing_public_key"`
+	SigningPrivateKey []byte              `json:"signing_private_key"`
+	EncSigningKey     EncryptedPrivateKey `json:"encrypted_signing_key"`
+
+	// buffers back PrivateKey and SigningPrivateKey after a login.
+	buffers []*SecureBuffer
+}
+
+// SetPrivateKeys points PrivateKey and SigningPrivateKey at decrypted
+// buffers, which Destroy releases. signing may be nil.
+func (k *KeyPair) SetPrivateKeys(private, signing *SecureBuffer) {
+	k.PrivateKey = private.Bytes()
+	k.SigningPrivateKey = signing.Bytes()
+	k.buffers = append(k.buffers, private, signing)
+}
+
+// Destroy zeroes the private keys. The KeyPair must not be used afterwards.
+func (k *KeyPair) Destroy() {
+	if k == nil {
+		return
+	}
+
+	zero(k.PrivateKey)
+	zero(k.SigningPrivateKey)
+	for _, buf := range k.buffers {
+		buf.Destroy()
+	}
+	k.PrivateKey, k.SigningPrivateKey, k.buffers = nil, nil, nil
 }
+
 type EncryptedPrivateKey struct {
 	EncryptedUserPrivateKey []byte `json:"encrypted_user_private_key"`
 	PrivateKeySalt          []byte `json:"private_key_salt"`
 	PrivateKeyNonce         []byte `json:"private_key_nonce"`
 }
 
-func encryptPrivateKey(priv *ecdh.PrivateKey, password string, params *Argon2idParams) (*EncryptedPrivateKey, error) {
+func encryptPrivateKey(priv []byte, password string, params *Argon2idParams) (*EncryptedPrivateKey, error) {
 
 	salt := make([]byte, 16)
 	_, err := rand.Read(salt)
@@ -51,21 +81,13 @@ func encryptPrivateKey(priv *ecdh.PrivateKey, password string, params *Argon2idP
 		params.Parallelism,
 		params.KeyLength,
 	)
+	defer zero(encryptionKey)
 
-	block, err := aes.NewCipher(encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
+	encryptedPrivateKey, nonce, err := seal(encryptionKey, KDFArgon2id, CompressionNone, priv)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = rand.Read(nonce); err != nil {
-		return nil, err
-	}
 
-	encryptedPrivateKey := gcm.Seal(nil, nonce, priv.Bytes(), nil)
 	return &EncryptedPrivateKey{
 		EncryptedUserPrivateKey: encryptedPrivateKey,
 		PrivateKeySalt:          salt,
@@ -73,11 +95,13 @@ func encryptPrivateKey(priv *ecdh.PrivateKey, password string, params *Argon2idP
 	}, nil
 }
 
+// DecryptPrivateKey returns the private key in a SecureBuffer the caller
+// must Destroy.
 func DecryptPrivateKey(
 	enc *EncryptedPrivateKey,
 	password string,
 	params *Argon2idParams,
-) ([]byte, error) {
+) (*SecureBuffer, error) {
 
 	// 1. Derive the same encryption key using Argon2id
 	encryptionKey := argon2.IDKey(
@@ -89,25 +113,10 @@ func DecryptPrivateKey(
 		params.KeyLength,
 	)
 
-	// 2. Create AES block cipher
-	block, err := aes.NewCipher(encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	// 3. Create GCM instance
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
+	defer zero(encryptionKey)
 
-	// 4. Decrypt (Open = authenticated decrypt)
-	plaintextPrivKey, err := gcm.Open(
-		nil,
-		enc.PrivateKeyNonce,
-		enc.EncryptedUserPrivateKey,
-		nil,
-	)
+	// 2. Decrypt (authenticated)
+	plaintextPrivKey, err := open(encryptionKey, enc.EncryptedUserPrivateKey, enc.PrivateKeyNonce, KDFArgon2id)
 	if err != nil {
 		// This error covers:
 		// - wrong password
@@ -117,8 +126,9 @@ func DecryptPrivateKey(
 		return nil, err
 	}
 
-	// 5. Sanity check (X25519 private keys are 32 bytes)
-	if len(plaintextPrivKey) != 32 {
+	// 3. Sanity check (X25519 private keys and Ed25519 seeds are 32 bytes)
+	if plaintextPrivKey.Len() != 32 {
+		plaintextPrivKey.Destroy()
 		return nil, errors.New("invalid private key length")
 	}
 
@@ -133,14 +143,27 @@ func GenerateKeyPair(password string) (*KeyPair, error) {
 		return nil, err
 	}
 
-	encryptedKey, err := encryptPrivateKey(priv, password, &DefaultArgon2Params)
+	encryptedKey, err := encryptPrivateKey(priv.Bytes(), password, &DefaultArgon2Params)
+	if err != nil {
+		return nil, err
+	}
+
+	signingPub, signingSeed, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSigningKey, err := encryptPrivateKey(signingSeed, password, &DefaultArgon2Params)
 	if err != nil {
 		return nil, err
 	}
 
 	return &KeyPair{
-		PrivateKey: priv.Bytes(),
-		PublicKey:  priv.PublicKey().Bytes(),
-		EncKey:     *encryptedKey,
+		PrivateKey:        priv.Bytes(),
+		PublicKey:         priv.PublicKey().Bytes(),
+		EncKey:            *encryptedKey,
+		SigningPublicKey:  signingPub,
+		SigningPrivateKey: signingSeed,
+		EncSigningKey:     *encryptedSigningKey,
 	}, nil
 }
@@ -0,0 +1,67 @@
+package cryptutils
+
+// SecureBuffer holds key material or plaintext. Where the platform allows
+// it the bytes live outside the Go heap in memory that is locked against
+// swapping, excluded from core dumps and surrounded by inaccessible guard
+// pages. Everywhere else they are at least zeroed by Destroy.
+//
+// Bytes must not be used after Destroy; with locked memory doing so faults.
+type SecureBuffer struct {
+	data []byte
+
+	// region is the whole mapping including guard pages, nil for buffers
+	// on the Go heap.
+	region []byte
+}
+
+// NewSecureBuffer allocates a zeroed buffer of size bytes.
+func NewSecureBuffer(size int) *SecureBuffer {
+	if size == 0 {
+		return &SecureBuffer{data: []byte{}}
+	}
+
+	if b := allocLocked(size); b != nil {
+		return b
+	}
+
+	return &SecureBuffer{data: make([]byte, size)}
+}
+
+// SecureBufferFrom moves b into a new buffer and zeroes b.
+func SecureBufferFrom(b []byte) *SecureBuffer {
+	buf := NewSecureBuffer(len(b))
+	copy(buf.data, b)
+	zero(b)
+
+	return buf
+}
+
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+func (b *SecureBuffer) Len() int {
+	return len(b.Bytes())
+}
+
+// Destroy zeroes and releases the buffer. It is safe to call on a nil or
+// already destroyed buffer.
+func (b *SecureBuffer) Destroy() {
+	if b == nil || b.data == nil {
+		return
+	}
+
+	zero(b.data)
+	if b.region != nil {
+		freeLocked(b.region)
+		b.region = nil
+	}
+	b.data = nil
+}
+
+func zero(b []byte) {
+	clear(b)
+}
@@ -0,0 +1,39 @@
+package cryptutils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps size bytes between two PROT_NONE guard pages. The data
+// ends right before the trailing guard page so overruns fault. It returns
+// nil if the mapping cannot be made; failing to lock is tolerated, e.g.
+// when RLIMIT_MEMLOCK is exhausted.
+func allocLocked(size int) *SecureBuffer {
+	pageSize := os.Getpagesize()
+	dataPages := (size + pageSize - 1) / pageSize
+	total := (dataPages + 2) * pageSize
+
+	region, err := unix.Mmap(-1, 0, total, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil
+	}
+
+	inner := region[pageSize : total-pageSize]
+	if unix.Mprotect(region[:pageSize], unix.PROT_NONE) != nil || unix.Mprotect(region[total-pageSize:], unix.PROT_NONE) != nil {
+		unix.Munmap(region)
+		return nil
+	}
+	unix.Mlock(inner)
+	unix.Madvise(inner, unix.MADV_DONTDUMP)
+
+	start := len(inner) - size
+	return &SecureBuffer{data: inner[start:len(inner):len(inner)], region: region}
+}
+
+func freeLocked(region []byte) {
+	pageSize := os.Getpagesize()
+	unix.Munlock(region[pageSize : len(region)-pageSize])
+	unix.Munmap(region)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cryptutils
+
+// Without mmap and mlock SecureBuffer falls back to the Go heap.
+func allocLocked(size int) *SecureBuffer {
+	return nil
+}
+
+func freeLocked(region []byte) {}
@@ -0,0 +1,60 @@
+package cryptutils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// GenerateSigningKey returns an Ed25519 public key and the 32 byte seed of
+// its private key.
+func GenerateSigningKey() ([]byte, []byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(priv)
+
+	return pub, priv.Seed(), nil
+}
+
+// SigningPublicKey returns the Ed25519 public key belonging to seed.
+func SigningPublicKey(seed []byte) []byte {
+	priv := ed25519.NewKeyFromSeed(seed)
+	defer zero(priv)
+
+	// Public returns a copy, which outlives priv.
+	return priv.Public().(ed25519.PublicKey)
+}
+
+// SignVersion signs the stored form of an env version on behalf of author.
+func SignVersion(seed []byte, projectId, envName, author string, versionHash []byte) ([]byte, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid signing key length")
+	}
+
+	priv := ed25519.NewKeyFromSeed(seed)
+	defer zero(priv)
+
+	return ed25519.Sign(priv, signedVersionMessage(projectId, envName, author, versionHash)), nil
+}
+
+func VerifyVersionSignature(publicKey []byte, projectId, envName, author string, versionHash, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(publicKey, signedVersionMessage(projectId, envName, author, versionHash), signature)
+}
+
+func signedVersionMessage(projectId, envName, author string, versionHash []byte) []byte {
+	var msg []byte
+	msg = append(msg, "envcrypt-version-sig-v1"...)
+	for _, field := range [][]byte{[]byte(projectId), []byte(envName), []byte(author), versionHash} {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(field)))
+		msg = append(msg, field...)
+	}
+
+	return msg
+}
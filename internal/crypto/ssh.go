@@ -0,0 +1,152 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// An OpenSSH ed25519 key can stand in for a password protected keypair.
+// Its Ed25519 key signs versions as is, and the X25519 key projects are
+// wrapped to is derived from it the same way age and libsodium do: the
+// private scalar from the hashed seed, the public key by mapping the
+// Edwards point to its Montgomery u-coordinate.
+
+const SSHIdentityType = "ssh-ed25519"
+
+// ParseSSHPublicKey returns the X25519 and Ed25519 public keys of an
+// ssh-ed25519 key in authorized_keys format.
+func ParseSSHPublicKey(authorizedKey []byte) ([]byte, []byte, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, nil, fmt.Errorf("%s keys are not supported, only ssh-ed25519", key.Type())
+	}
+
+	signingPublicKey := []byte(key.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey))
+	publicKey, err := ed25519PublicKeyToX25519(signingPublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, signingPublicKey, nil
+}
+
+// SSHKeyPair derives a keypair from an OpenSSH ed25519 private key.
+// passphrase is only called for encrypted keys.
+func SSHKeyPair(pemBytes []byte, passphrase func() ([]byte, error)) (*KeyPair, error) {
+	raw, err := ssh.ParseRawPrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		pass, perr := passphrase()
+		if perr != nil {
+			return nil, perr
+		}
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, pass)
+		zero(pass)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key ed25519.PrivateKey
+	switch k := raw.(type) {
+	case *ed25519.PrivateKey:
+		key = *k
+	case ed25519.PrivateKey:
+		key = k
+	default:
+		return nil, errors.New("only ssh-ed25519 keys are supported")
+	}
+	defer zero(key)
+
+	signingKey := SecureBufferFrom(key.Seed())
+
+	digest := sha512.Sum512(signingKey.Bytes())
+	privateKey := SecureBufferFrom(digest[:32])
+	zero(digest[:])
+	scalar := privateKey.Bytes()
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+
+	keyPair := &KeyPair{SigningPublicKey: bytes.Clone(key.Public().(ed25519.PublicKey))}
+	keyPair.SetPrivateKeys(privateKey, signingKey)
+
+	if keyPair.PublicKey, err = X25519PublicKey(keyPair.PrivateKey); err != nil {
+		keyPair.Destroy()
+		return nil, err
+	}
+
+	// Both derivations must agree, or the key could not be shared to.
+	converted, err := ed25519PublicKeyToX25519(keyPair.SigningPublicKey)
+	if err != nil || !bytes.Equal(converted, keyPair.PublicKey) {
+		keyPair.Destroy()
+		return nil, errors.New("ssh key does not convert to a consistent X25519 key")
+	}
+
+	return keyPair, nil
+}
+
+var (
+	curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	// edwards25519D is -121665/121666 mod p.
+	edwards25519D = new(big.Int).Mod(
+		new(big.Int).Mul(big.NewInt(-121665), new(big.Int).ModInverse(big.NewInt(121666), curve25519P)),
+		curve25519P,
+	)
+)
+
+// ed25519PublicKeyToX25519 maps an Ed25519 public key to u = (1+y)/(1-y).
+// Public keys are not secret, so math/big is fine here.
+func ed25519PublicKeyToX25519(publicKey []byte) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key length")
+	}
+	p := curve25519P
+
+	le := bytes.Clone(publicKey)
+	sign := le[31] >> 7
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	// The point must be on the curve: x² = (y²-1)/(dy²+1) has a root.
+	y2 := new(big.Int).Mul(y, y)
+	num := new(big.Int).Sub(y2, big.NewInt(1))
+	den := new(big.Int).Add(new(big.Int).Mul(edwards25519D, y2), big.NewInt(1))
+	x2 := new(big.Int).Mul(num, new(big.Int).ModInverse(den.Mod(den, p), p))
+	x2.Mod(x2, p)
+	if x2.Sign() == 0 && sign == 1 || x2.Sign() != 0 && big.Jacobi(x2, p) != 1 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	oneMinusY := new(big.Int).Sub(big.NewInt(1), y)
+	oneMinusY.Mod(oneMinusY, p)
+	if oneMinusY.Sign() == 0 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, new(big.Int).ModInverse(oneMinusY, p))
+	u.Mod(u, p)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverse(out), nil
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
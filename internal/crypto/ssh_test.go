@@ -0,0 +1,115 @@
+package cryptutils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The keys expected from testdata/ssh. id_ed25519 holds the seed of
+// libsodium's ed25519_convert test, whose crypto_sign_ed25519_pk_to_curve25519
+// and crypto_sign_ed25519_sk_to_curve25519 results are below.
+// id_ed25519_encrypted was made by ssh-keygen with the passphrase
+// "correct horse", its X25519 key was computed with filippo.io/edwards25519
+// as age's agessh package does.
+var sshKeyTests = []struct {
+	file       string
+	passphrase string
+	publicKey  string
+	privateKey string
+}{
+	{
+		file:       "testdata/ssh/id_ed25519",
+		publicKey:  "f1814f0e8ff1043d8a44d25babff3cedcae6c22c3edaa48f857ae70de2baae50",
+		privateKey: "8052030376d47112be7f73ed7a019293dd12ad910b654455798b4667d73de166",
+	},
+	{
+		file:       "testdata/ssh/id_ed25519_encrypted",
+		passphrase: "correct horse",
+		publicKey:  "186f8eaca39b7680b850defa01fb6311248ac67234c3eff665df37200f8dab53",
+	},
+}
+
+func TestSSHKeyPair(t *testing.T) {
+	for _, tt := range sshKeyTests {
+		t.Run(tt.file, func(t *testing.T) {
+			asked := false
+			keyPair, err := SSHKeyPair(readFile(t, tt.file), func() ([]byte, error) {
+				asked = true
+				return []byte(tt.passphrase), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer keyPair.Destroy()
+
+			if asked != (tt.passphrase != "") {
+				t.Errorf("asked for a passphrase: %v", asked)
+			}
+			if !bytes.Equal(keyPair.PublicKey, mustHex(t, tt.publicKey)) {
+				t.Errorf("X25519 public key %x, want %s", keyPair.PublicKey, tt.publicKey)
+			}
+			if tt.privateKey != "" && !bytes.Equal(keyPair.PrivateKey, mustHex(t, tt.privateKey)) {
+				t.Errorf("X25519 private key %x, want %s", keyPair.PrivateKey, tt.privateKey)
+			}
+
+			publicKey, signingPublicKey, err := ParseSSHPublicKey(readFile(t, tt.file+".pub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(publicKey, keyPair.PublicKey) || !bytes.Equal(signingPublicKey, keyPair.SigningPublicKey) {
+				t.Error("keys of the .pub file differ from the private key's")
+			}
+		})
+	}
+}
+
+func TestSSHKeyPairWrongPassphrase(t *testing.T) {
+	pemBytes := readFile(t, "testdata/ssh/id_ed25519_encrypted")
+
+	_, err := SSHKeyPair(pemBytes, func() ([]byte, error) { return []byte("wrong horse"), nil })
+	if !errors.Is(err, x509.IncorrectPasswordError) {
+		t.Errorf("got %v, want x509.IncorrectPasswordError", err)
+	}
+
+	cancelled := errors.New("cancelled")
+	if _, err := SSHKeyPair(pemBytes, func() ([]byte, error) { return nil, cancelled }); !errors.Is(err, cancelled) {
+		t.Errorf("got %v, want the passphrase error", err)
+	}
+}
+
+func TestParseSSHPublicKeyRejects(t *testing.T) {
+	if _, _, err := ParseSSHPublicKey([]byte("not a key")); err == nil {
+		t.Error("accepted a malformed key")
+	}
+	if _, _, err := ParseSSHPublicKey(readFile(t, "testdata/ssh/id_ecdsa.pub")); err == nil || !strings.Contains(err.Error(), "only ssh-ed25519") {
+		t.Errorf("got %v, want ecdsa keys refused", err)
+	}
+
+	// An Ed25519 public key that is not a point on the curve.
+	if _, err := ed25519PublicKeyToX25519(bytes.Repeat([]byte{0xff}, 32)); err == nil {
+		t.Error("converted an invalid Ed25519 public key")
+	}
+}
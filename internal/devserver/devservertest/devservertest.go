@@ -0,0 +1,28 @@
+// Package devservertest runs the development server inside tests.
+package devservertest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/envcrypts/envcrypt_cli/internal/devserver"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+// Start serves a fresh in-memory store and points services.DefaultBackend at
+// it until the test finishes. Tests using it must not run in parallel.
+func Start(tb testing.TB) *httptest.Server {
+	tb.Helper()
+
+	server := httptest.NewServer(devserver.New(devserver.NewMemoryStore()))
+
+	previous := services.DefaultBackend
+	services.DefaultBackend = &services.HTTPBackend{BaseURL: server.URL}
+
+	tb.Cleanup(func() {
+		services.DefaultBackend = previous
+		server.Close()
+	})
+
+	return server
+}
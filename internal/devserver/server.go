@@ -0,0 +1,679 @@
+// Package devserver is an in-memory implementation of the envcrypt server
+// API used by the services package. It is meant for local development and
+// end to end tests, not for production.
+package devserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+var (
+	errUnauthorized   = &httpError{http.StatusUnauthorized, "invalid email or password"}
+	errForbidden      = &httpError{http.StatusForbidden, "user has no access to this project"}
+	errUserExists     = &httpError{http.StatusConflict, "user already exists"}
+	errProjectExists  = &httpError{http.StatusConflict, "project already exists"}
+	errAlreadyMember  = &httpError{http.StatusConflict, "user is already a member"}
+	errAccountExists  = &httpError{http.StatusConflict, "service account already exists"}
+	errNoSuchAccount  = &httpError{http.StatusNotFound, "service account not found"}
+	errAccountRevoked = &httpError{http.StatusConflict, "service account is revoked"}
+	errInvalidToken   = &httpError{http.StatusUnauthorized, "invalid or revoked service account token"}
+	errNoSuchUser     = &httpError{http.StatusNotFound, "user not found"}
+	errNoSuchProject  = &httpError{http.StatusNotFound, "project not found"}
+	errNoSuchEnv      = &httpError{http.StatusNotFound, "env not found"}
+	errNoSuchVersion  = &httpError{http.StatusNotFound, "env version not found"}
+	errInvalidRequest = &httpError{http.StatusBadRequest, "invalid request body"}
+)
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type Server struct {
+	store *Store
+	mux   *http.ServeMux
+}
+
+func New(store *Store) *Server {
+	s := &Server{store: store, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("POST /users/create", s.handleUserCreate)
+	s.mux.HandleFunc("POST /users/login", s.handleUserLogin)
+	s.mux.HandleFunc("POST /users/keys", s.handleUserKeys)
+	s.mux.HandleFunc("POST /projects/create", s.handleProjectCreate)
+	s.mux.HandleFunc("POST /projects/keys", s.handleProjectKeys)
+	s.mux.HandleFunc("POST /projects/members/add", s.handleProjectMemberAdd)
+	s.mux.HandleFunc("POST /service-accounts/create", s.handleServiceAccountCreate)
+	s.mux.HandleFunc("POST /service-accounts/rotate", s.handleServiceAccountRotate)
+	s.mux.HandleFunc("POST /service-accounts/revoke", s.handleServiceAccountRevoke)
+	s.mux.HandleFunc("POST /service-accounts/list", s.handleServiceAccountList)
+	s.mux.HandleFunc("POST /service-accounts/login", s.handleServiceAccountLogin)
+	s.mux.HandleFunc("POST /env/create", s.handleEnvCreate)
+	s.mux.HandleFunc("POST /env/update", s.handleEnvUpdate)
+	s.mux.HandleFunc("POST /env/search", s.handleEnvSearch)
+	s.mux.HandleFunc("POST /env/search/all", s.handleEnvSearchAll)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
+	var req services.CreateRequestBody
+	if !decode(w, r, &req) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.store.update(func(st *state) error {
+		if _, exists := st.Users[req.Email]; exists {
+			return errUserExists
+		}
+
+		st.Users[req.Email] = &user{
+			Id:                      uuid.New(),
+			Email:                   req.Email,
+			PasswordHash:            hash,
+			PublicKey:               req.PublicKey,
+			EncryptedUserPrivateKey: req.EncryptedUserPrivateKey,
+			PrivateKeySalt:          req.PrivateKeySalt,
+			PrivateKeyNonce:         req.PrivateKeyNonce,
+			ArgonParams:             cryptutils.DefaultArgon2Params,
+			SigningPublicKey:        req.SigningPublicKey,
+			EncryptedSigningKey:     req.EncryptedSigningKey,
+			SigningKeySalt:          req.SigningKeySalt,
+			SigningKeyNonce:         req.SigningKeyNonce,
+			IdentityType:            req.IdentityType,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, messageResponse{Message: "user created"})
+}
+
+func (s *Server) handleUserLogin(w http.ResponseWriter, r *http.Request) {
+	var req services.LoginRequestBody
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var u user
+	err := s.store.view(func(st *state) error {
+		found, exists := st.Users[req.Email]
+		if !exists {
+			return errUnauthorized
+		}
+		u = *found
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(req.Password)) != nil {
+		writeError(w, errUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, services.LoginResponseBody{
+		Message: "login successful",
+		User: services.UserBody{
+			Id:                      u.Id,
+			Email:                   u.Email,
+			PublicKey:               u.PublicKey,
+			EncryptedUserPrivateKey: u.EncryptedUserPrivateKey,
+			PrivateKeySalt:          u.PrivateKeySalt,
+			PrivateKeyNonce:         u.PrivateKeyNonce,
+			ArgonParams:             u.ArgonParams,
+			SigningPublicKey:        u.SigningPublicKey,
+			EncryptedSigningKey:     u.EncryptedSigningKey,
+			SigningKeySalt:          u.SigningKeySalt,
+			SigningKeyNonce:         u.SigningKeyNonce,
+			IdentityType:            u.IdentityType,
+		},
+	})
+}
+
+func (s *Server) handleUserKeys(w http.ResponseWriter, r *http.Request) {
+	var req services.GetUserKeysRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp services.UserKeysResponse
+	err := s.store.view(func(st *state) error {
+		u, exists := st.Users[req.Email]
+		if !exists {
+			return errNoSuchUser
+		}
+
+		resp = services.UserKeysResponse{
+			Id:               u.Id,
+			Email:            u.Email,
+			PublicKey:        u.PublicKey,
+			SigningPublicKey: u.SigningPublicKey,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleProjectCreate(w http.ResponseWriter, r *http.Request) {
+	var req services.ProjectCreateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var projectId uuid.UUID
+	err := s.store.update(func(st *state) error {
+		if userById(st, req.UserId) == nil {
+			return errNoSuchUser
+		}
+		if findProject(st, req.Name, req.UserId) != nil {
+			return errProjectExists
+		}
+
+		projectId = uuid.New()
+		st.Projects[projectId] = &project{
+			Id:      projectId,
+			Name:    req.Name,
+			OwnerId: req.UserId,
+			Members: map[uuid.UUID]cryptutils.WrappedKey{
+				req.UserId: {
+					WrappedPMK:       req.WrappedPMK,
+					WrapNonce:        req.WrapNonce,
+					WrapEphemeralPub: req.EphemeralPublicKey,
+				},
+			},
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{"message": "project created", "project_id": projectId})
+}
+
+func (s *Server) handleProjectKeys(w http.ResponseWriter, r *http.Request) {
+	var req services.GetUserProjectRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp services.GetUserProjectResponse
+	err := s.store.view(func(st *state) error {
+		p := findProject(st, req.ProjectName, req.UserId)
+		if p == nil {
+			return errNoSuchProject
+		}
+
+		wrapped := p.Members[req.UserId]
+		resp = services.GetUserProjectResponse{
+			ProjectId:          p.Id,
+			WrappedPMK:         wrapped.WrappedPMK,
+			WrapNonce:          wrapped.WrapNonce,
+			EphemeralPublicKey: wrapped.WrapEphemeralPub,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleProjectMemberAdd(w http.ResponseWriter, r *http.Request) {
+	var req services.AddProjectMemberRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	err := s.store.update(func(st *state) error {
+		if err := authorize(st, req.ProjectId, req.Email); err != nil {
+			return err
+		}
+		if userById(st, req.MemberId) == nil {
+			return errNoSuchUser
+		}
+
+		p := st.Projects[req.ProjectId]
+		if _, member := p.Members[req.MemberId]; member {
+			return errAlreadyMember
+		}
+
+		p.Members[req.MemberId] = cryptutils.WrappedKey{
+			WrappedPMK:       req.WrappedPMK,
+			WrapNonce:        req.WrapNonce,
+			WrapEphemeralPub: req.EphemeralPublicKey,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, messageResponse{Message: "member added"})
+}
+
+func (s *Server) handleServiceAccountCreate(w http.ResponseWriter, r *http.Request) {
+	var req services.ServiceAccountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(req.Secret, bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.store.update(func(st *state) error {
+		if err := authorize(st, req.ProjectId, req.Email); err != nil {
+			return err
+		}
+		if findServiceAccount(st, req.ProjectId, req.Name) != nil {
+			return errAccountExists
+		}
+
+		st.ServiceAccounts[req.Id] = &serviceAccount{
+			ServiceAccountInfo: services.ServiceAccountInfo{
+				Id:        req.Id,
+				Name:      req.Name,
+				Envs:      req.Envs,
+				CreatedBy: req.Email,
+				CreatedAt: time.Now().UTC(),
+			},
+			ProjectId:  req.ProjectId,
+			SecretHash: hash,
+			PublicKey:  req.PublicKey,
+			WrappedKey: cryptutils.WrappedKey{
+				WrappedPMK:       req.WrappedPMK,
+				WrapNonce:        req.WrapNonce,
+				WrapEphemeralPub: req.EphemeralPublicKey,
+			},
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, messageResponse{Message: "service account created"})
+}
+
+func (s *Server) handleServiceAccountRotate(w http.ResponseWriter, r *http.Request) {
+	var req services.ServiceAccountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(req.Secret, bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.store.update(func(st *state) error {
+		account, err := activeServiceAccount(st, req.ProjectId, req.Email, req.Name)
+		if err != nil {
+			return err
+		}
+
+		account.SecretHash = hash
+		account.PublicKey = req.PublicKey
+		account.WrappedKey = cryptutils.WrappedKey{
+			WrappedPMK:       req.WrappedPMK,
+			WrapNonce:        req.WrapNonce,
+			WrapEphemeralPub: req.EphemeralPublicKey,
+		}
+		account.RotatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, messageResponse{Message: "service account rotated"})
+}
+
+func (s *Server) handleServiceAccountRevoke(w http.ResponseWriter, r *http.Request) {
+	var req services.RevokeServiceAccountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	err := s.store.update(func(st *state) error {
+		account, err := activeServiceAccount(st, req.ProjectId, req.Email, req.Name)
+		if err != nil {
+			return err
+		}
+
+		account.SecretHash = nil
+		account.WrappedKey = cryptutils.WrappedKey{}
+		account.RevokedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, messageResponse{Message: "service account revoked"})
+}
+
+func (s *Server) handleServiceAccountList(w http.ResponseWriter, r *http.Request) {
+	var req services.ListServiceAccountsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp := []services.ServiceAccountInfo{}
+	err := s.store.view(func(st *state) error {
+		if err := authorize(st, req.ProjectId, req.Email); err != nil {
+			return err
+		}
+
+		for _, account := range st.ServiceAccounts {
+			if account.ProjectId == req.ProjectId {
+				resp = append(resp, account.ServiceAccountInfo)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleServiceAccountLogin(w http.ResponseWriter, r *http.Request) {
+	var req services.ServiceLoginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp services.ServiceLoginResponse
+	err := s.store.view(func(st *state) error {
+		account, exists := st.ServiceAccounts[req.Id]
+		if !exists || account.Revoked() {
+			return errInvalidToken
+		}
+		if bcrypt.CompareHashAndPassword(account.SecretHash, req.Secret) != nil {
+			return errInvalidToken
+		}
+
+		resp = services.ServiceLoginResponse{
+			Id:                 account.Id,
+			Name:               account.Name,
+			ProjectId:          account.ProjectId,
+			ProjectName:        st.Projects[account.ProjectId].Name,
+			Envs:               account.Envs,
+			PublicKey:          account.PublicKey,
+			WrappedPMK:         account.WrappedKey.WrappedPMK,
+			WrapNonce:          account.WrappedKey.WrapNonce,
+			EphemeralPublicKey: account.WrappedKey.WrapEphemeralPub,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleEnvCreate(w http.ResponseWriter, r *http.Request) {
+	var req services.AddEnvRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	version, err := s.appendVersion(req.ProjectId, req.Email, req.EnvName, req.CipherText, req.Nonce, req.Metadata, false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{"message": "env created", "version": version})
+}
+
+func (s *Server) handleEnvUpdate(w http.ResponseWriter, r *http.Request) {
+	var req services.UpdateEnvRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	version, err := s.appendVersion(req.ProjectId, req.Email, req.EnvName, req.CipherText, req.Nonce, req.Metadata, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"message": "env updated", "version": version})
+}
+
+// appendVersion stores a new version numbered one past the latest. Updates
+// require the environment to exist already.
+func (s *Server) appendVersion(projectId uuid.UUID, email, envName string, cipherText, nonce []byte, metadata services.Metadata, mustExist bool) (int32, error) {
+	var version int32
+	err := s.store.update(func(st *state) error {
+		if err := authorize(st, projectId, email); err != nil {
+			return err
+		}
+
+		key := envKey(projectId, envName)
+		versions := st.Envs[key]
+		if mustExist && len(versions) == 0 {
+			return errNoSuchEnv
+		}
+
+		version = int32(len(versions)) + 1
+		st.Envs[key] = append(versions, services.EnvResponse{
+			CipherText: cipherText,
+			Nonce:      nonce,
+			Version:    version,
+			Metadata:   metadata,
+		})
+		return nil
+	})
+
+	return version, err
+}
+
+func (s *Server) handleEnvSearch(w http.ResponseWriter, r *http.Request) {
+	var req services.GetEnvRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp services.GetEnvResponse
+	err := s.store.view(func(st *state) error {
+		if err := authorizeRead(st, req.ProjectId, req.Email, req.EnvName); err != nil {
+			return err
+		}
+
+		for _, version := range st.Envs[envKey(req.ProjectId, req.EnvName)] {
+			if version.Version == req.Version {
+				resp = services.GetEnvResponse{CipherText: version.CipherText, Nonce: version.Nonce}
+				return nil
+			}
+		}
+		return errNoSuchVersion
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleEnvSearchAll(w http.ResponseWriter, r *http.Request) {
+	var req services.GetEnvVersionsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var resp services.GetEnvVersionsResponse
+	err := s.store.view(func(st *state) error {
+		if err := authorizeRead(st, req.ProjectId, req.Email, req.EnvName); err != nil {
+			return err
+		}
+
+		resp.EnvVersions = append([]services.EnvResponse{}, st.Envs[envKey(req.ProjectId, req.EnvName)]...)
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func authorize(st *state, projectId uuid.UUID, email string) error {
+	p, exists := st.Projects[projectId]
+	if !exists {
+		return errNoSuchProject
+	}
+
+	u, exists := st.Users[email]
+	if !exists {
+		return errForbidden
+	}
+	if _, member := p.Members[u.Id]; !member {
+		return errForbidden
+	}
+
+	return nil
+}
+
+// authorizeRead is authorize, extended to service accounts allowed to read
+// envName.
+func authorizeRead(st *state, projectId uuid.UUID, email, envName string) error {
+	id, isService := services.ParseServicePrincipal(email)
+	if !isService {
+		return authorize(st, projectId, email)
+	}
+
+	account, exists := st.ServiceAccounts[id]
+	if !exists || account.Revoked() || account.ProjectId != projectId || !slices.Contains(account.Envs, envName) {
+		return errForbidden
+	}
+
+	return nil
+}
+
+func findServiceAccount(st *state, projectId uuid.UUID, name string) *serviceAccount {
+	for _, account := range st.ServiceAccounts {
+		if account.ProjectId == projectId && account.Name == name {
+			return account
+		}
+	}
+	return nil
+}
+
+// activeServiceAccount returns the unrevoked service account name of a
+// project email is a member of.
+func activeServiceAccount(st *state, projectId uuid.UUID, email, name string) (*serviceAccount, error) {
+	if err := authorize(st, projectId, email); err != nil {
+		return nil, err
+	}
+
+	account := findServiceAccount(st, projectId, name)
+	if account == nil {
+		return nil, errNoSuchAccount
+	}
+	if account.Revoked() {
+		return nil, errAccountRevoked
+	}
+
+	return account, nil
+}
+
+func userById(st *state, id uuid.UUID) *user {
+	for _, u := range st.Users {
+		if u.Id == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func findProject(st *state, name string, userId uuid.UUID) *project {
+	for _, p := range st.Projects {
+		if _, member := p.Members[userId]; member && p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, errInvalidRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) {
+		httpErr = &httpError{http.StatusInternalServerError, err.Error()}
+	}
+
+	writeJSON(w, httpErr.status, map[string]string{"error": httpErr.message})
+}
@@ -0,0 +1,147 @@
+package devserver
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+	"github.com/google/uuid"
+)
+
+type user struct {
+	Id           uuid.UUID `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash []byte    `json:"password_hash"`
+
+	PublicKey               []byte                    `json:"public_key"`
+	EncryptedUserPrivateKey []byte                    `json:"encrypted_user_private_key"`
+	PrivateKeySalt          []byte                    `json:"private_key_salt"`
+	PrivateKeyNonce         []byte                    `json:"private_key_nonce"`
+	ArgonParams             cryptutils.Argon2idParams `json:"argon_params"`
+
+	SigningPublicKey    []byte `json:"signing_public_key,omitempty"`
+	EncryptedSigningKey []byte `json:"encrypted_signing_key,omitempty"`
+	SigningKeySalt      []byte `json:"signing_key_salt,omitempty"`
+	SigningKeyNonce     []byte `json:"signing_key_nonce,omitempty"`
+
+	IdentityType string `json:"identity_type,omitempty"`
+}
+
+type project struct {
+	Id      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	OwnerId uuid.UUID `json:"owner_id"`
+
+	// Members maps every user with access to the PMK wrapped for them.
+	Members map[uuid.UUID]cryptutils.WrappedKey `json:"members"`
+}
+
+type serviceAccount struct {
+	services.ServiceAccountInfo
+	ProjectId  uuid.UUID             `json:"project_id"`
+	SecretHash []byte                `json:"secret_hash"`
+	PublicKey  []byte                `json:"public_key"`
+	WrappedKey cryptutils.WrappedKey `json:"wrapped_key"`
+}
+
+type state struct {
+	Users           map[string]*user                  `json:"users"`
+	Projects        map[uuid.UUID]*project            `json:"projects"`
+	Envs            map[string][]services.EnvResponse `json:"envs"`
+	ServiceAccounts map[uuid.UUID]*serviceAccount     `json:"service_accounts"`
+}
+
+func envKey(projectId uuid.UUID, envName string) string {
+	return projectId.String() + "/" + envName
+}
+
+// Store keeps the server state in memory and, when opened with a path,
+// rewrites it as JSON after every change.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	state state
+}
+
+func NewMemoryStore() *Store {
+	return &Store{
+		state: state{
+			Users:           make(map[string]*user),
+			Projects:        make(map[uuid.UUID]*project),
+			Envs:            make(map[string][]services.EnvResponse),
+			ServiceAccounts: make(map[uuid.UUID]*serviceAccount),
+		},
+	}
+}
+
+// OpenFileStore loads path if it exists and persists every change to it.
+func OpenFileStore(path string) (*Store, error) {
+	store := NewMemoryStore()
+	store.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.state); err != nil {
+		return nil, err
+	}
+	if store.state.ServiceAccounts == nil {
+		store.state.ServiceAccounts = make(map[uuid.UUID]*serviceAccount)
+	}
+
+	return store, nil
+}
+
+func (s *Store) view(fn func(st *state) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fn(&s.state)
+}
+
+func (s *Store) update(fn func(st *state) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := fn(&s.state); err != nil {
+		return err
+	}
+
+	return s.persist()
+}
+
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".devserver-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// AgeRecipients resolves age1... recipients and envcrypt users, given by
+// email, to X25519 public keys. Like sharing a project, users' keys must
+// have been verified with VerifyUserKey unless force is set.
+func AgeRecipients(recipients []string, force bool) ([][]byte, error) {
+	keys := make([][]byte, 0, len(recipients))
+	for _, recipient := range recipients {
+		if strings.HasPrefix(recipient, "age1") {
+			key, err := cryptutils.ParseAgeRecipient(recipient)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			continue
+		}
+
+		user, err := LookupUserKey(recipient)
+		if err != nil {
+			return nil, err
+		}
+		if !user.Verified && !force {
+			return nil, fmt.Errorf("key of %s (%s) is not verified, compare fingerprints and run `trust verify %s` or pass force", recipient, user.Fingerprint(), recipient)
+		}
+		keys = append(keys, user.PublicKey)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no age recipients")
+	}
+
+	return keys, nil
+}
+
+// ExportEnvAge encrypts the latest version of envName, in .env format, to
+// the age recipients and returns it along with its version number.
+func ExportEnvAge(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey, recipients [][]byte, armor bool) ([]byte, int32, error) {
+
+	env, version, err := PullLatestEnv(projectId, email, privateKey, envName, wrappedKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version == 0 {
+		return nil, 0, fmt.Errorf("%s has no versions yet", envName)
+	}
+
+	plaintext := cryptutils.NormalizeEnv(env)
+	defer clear(plaintext)
+
+	encrypted, err := cryptutils.AgeEncrypt(plaintext, recipients, armor)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encrypted, version, nil
+}
+
+// ReadAgeEnv decrypts an age encrypted .env file with the first matching
+// identity.
+func ReadAgeEnv(data []byte, identities [][]byte) (map[string]string, error) {
+	plaintext, err := cryptutils.AgeDecrypt(data, identities)
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Destroy()
+
+	env, err := cryptutils.ParseEnv(plaintext.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range env {
+		if err := cryptutils.ValidateEnvEntry(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return env, nil
+}
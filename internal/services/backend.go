@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend stores users, projects, wrapped project keys and encrypted env
+// versions. Implementations only ever see ciphertext and wrapped keys.
+type Backend interface {
+	Register(req CreateRequestBody) error
+	Login(req LoginRequestBody) (*UserBody, error)
+	GetUserKeys(req GetUserKeysRequest) (*UserKeysResponse, error)
+
+	CreateProject(req ProjectCreateRequest) error
+	GetProjectKeys(req GetUserProjectRequest) (*GetUserProjectResponse, error)
+	AddProjectMember(req AddProjectMemberRequest) error
+
+	// Service accounts may only read the envs they were created for and
+	// never write.
+	CreateServiceAccount(req ServiceAccountRequest) error
+	RotateServiceAccount(req ServiceAccountRequest) error
+	RevokeServiceAccount(req RevokeServiceAccountRequest) error
+	ListServiceAccounts(req ListServiceAccountsRequest) ([]ServiceAccountInfo, error)
+	ServiceLogin(req ServiceLoginRequest) (*ServiceLoginResponse, error)
+
+	// CreateEnv stores a version of an environment that may not exist yet,
+	// UpdateEnv one of an environment that already does.
+	CreateEnv(req AddEnvRequest) error
+	UpdateEnv(req UpdateEnvRequest) error
+	GetEnv(req GetEnvRequest) (*GetEnvResponse, error)
+	GetEnvVersions(req GetEnvVersionsRequest) ([]EnvResponse, error)
+}
+
+// DefaultBackend is used by every function in this package.
+var DefaultBackend Backend = &HTTPBackend{BaseURL: "http://localhost:8080"}
+
+// OpenBackend returns the backend for a location:
+//
+//	http://host:port, https://host  an envcrypt server
+//	file:PATH                       a local directory
+//	git:PATH                        a local directory inside a git work tree,
+//	                                every change is committed
+func OpenBackend(location string) (Backend, error) {
+	switch {
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return &HTTPBackend{BaseURL: strings.TrimRight(location, "/")}, nil
+	case strings.HasPrefix(location, "file:"):
+		return NewFSBackend(strings.TrimPrefix(location, "file:"), false)
+	case strings.HasPrefix(location, "git:"):
+		return NewFSBackend(strings.TrimPrefix(location, "git:"), true)
+	default:
+		return nil, fmt.Errorf("unsupported backend location %q", location)
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultCacheStaleAfter is the age after which a cached response is
+	// reported as stale when it is used.
+	DefaultCacheStaleAfter = 24 * time.Hour
+	// DefaultCacheMaxAge is the age after which a cached response is
+	// evicted and never used again.
+	DefaultCacheMaxAge = 30 * 24 * time.Hour
+)
+
+// ErrOffline is returned for every change attempted in offline mode.
+var ErrOffline = errors.New("not available in offline mode")
+
+// CachingBackend keeps a local copy of everything read through Backend so
+// commands keep working while it is unreachable. Only what the backend
+// itself stores is cached: encrypted private keys, wrapped project keys and
+// env ciphertexts, never anything decrypted.
+//
+// In Offline mode the backend is never contacted. Otherwise the cache is
+// used as a fallback when the backend cannot be reached.
+type CachingBackend struct {
+	Backend Backend
+	Dir     string
+	Offline bool
+
+	StaleAfter time.Duration
+	MaxAge     time.Duration
+
+	// Warnings receives a line for every response served from the cache.
+	// Nil means os.Stderr.
+	Warnings io.Writer
+
+	// down is set once the backend was found unreachable, so later reads
+	// go to the cache without waiting for it again.
+	down bool
+
+	// evicted is set once expired entries were removed, which walks the
+	// whole cache and is done only on the first store.
+	evicted bool
+}
+
+// NewCachingBackend caches responses of backend under ConfigDir()/cache,
+// separately for every location.
+func NewCachingBackend(backend Backend, location string, offline bool) (*CachingBackend, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(location))
+	return &CachingBackend{
+		Backend:    backend,
+		Dir:        filepath.Join(dir, hex.EncodeToString(sum[:8])),
+		Offline:    offline,
+		StaleAfter: DefaultCacheStaleAfter,
+		MaxAge:     DefaultCacheMaxAge,
+	}, nil
+}
+
+// CacheDir is where CachingBackend keeps its entries.
+func CacheDir() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "cache"), nil
+}
+
+// ClearCache removes every cached response.
+func ClearCache() error {
+	dir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+type cacheEntry struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (b *CachingBackend) Register(req CreateRequestBody) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.Register(req)
+}
+
+// Login caches the account including its encrypted private keys. Offline the
+// password is only checked by decrypting them.
+func (b *CachingBackend) Login(req LoginRequestBody) (*UserBody, error) {
+	var user UserBody
+	err := b.read(filepath.Join("users", pathKey(req.Email)), "account "+req.Email, &user, func() (any, error) {
+		return b.Backend.Login(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (b *CachingBackend) GetUserKeys(req GetUserKeysRequest) (*UserKeysResponse, error) {
+	var keys UserKeysResponse
+	err := b.read(filepath.Join("keys", pathKey(req.Email)), "keys of "+req.Email, &keys, func() (any, error) {
+		return b.Backend.GetUserKeys(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+func (b *CachingBackend) CreateProject(req ProjectCreateRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.CreateProject(req)
+}
+
+func (b *CachingBackend) GetProjectKeys(req GetUserProjectRequest) (*GetUserProjectResponse, error) {
+	var project GetUserProjectResponse
+	err := b.read(filepath.Join("projects", req.UserId.String(), pathKey(req.ProjectName)), "project "+req.ProjectName, &project, func() (any, error) {
+		return b.Backend.GetProjectKeys(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+func (b *CachingBackend) AddProjectMember(req AddProjectMemberRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.AddProjectMember(req)
+}
+
+func (b *CachingBackend) CreateServiceAccount(req ServiceAccountRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.CreateServiceAccount(req)
+}
+
+func (b *CachingBackend) RotateServiceAccount(req ServiceAccountRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.RotateServiceAccount(req)
+}
+
+func (b *CachingBackend) RevokeServiceAccount(req RevokeServiceAccountRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	return b.Backend.RevokeServiceAccount(req)
+}
+
+func (b *CachingBackend) ListServiceAccounts(req ListServiceAccountsRequest) ([]ServiceAccountInfo, error) {
+	if b.Offline {
+		return nil, ErrOffline
+	}
+	return b.Backend.ListServiceAccounts(req)
+}
+
+// ServiceLogin is cached like Login. Offline the secret is not checked, only
+// the private key in the token can unwrap the cached PMK.
+func (b *CachingBackend) ServiceLogin(req ServiceLoginRequest) (*ServiceLoginResponse, error) {
+	var resp ServiceLoginResponse
+	err := b.read(filepath.Join("service-accounts", req.Id.String()), "service account "+req.Id.String(), &resp, func() (any, error) {
+		return b.Backend.ServiceLogin(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (b *CachingBackend) CreateEnv(req AddEnvRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	if err := b.Backend.CreateEnv(req); err != nil {
+		return err
+	}
+	b.refreshHistory(GetEnvVersionsRequest{ProjectId: req.ProjectId, Email: req.Email, EnvName: req.EnvName})
+	return nil
+}
+
+func (b *CachingBackend) UpdateEnv(req UpdateEnvRequest) error {
+	if b.Offline {
+		return ErrOffline
+	}
+	if err := b.Backend.UpdateEnv(req); err != nil {
+		return err
+	}
+	b.refreshHistory(GetEnvVersionsRequest{ProjectId: req.ProjectId, Email: req.Email, EnvName: req.EnvName})
+	return nil
+}
+
+// refreshHistory re-caches the history of an env after a push. The push
+// pins the new version, so a cached history without it would later be
+// reported as a rollback. If it cannot be fetched the cached copy is
+// dropped instead.
+func (b *CachingBackend) refreshHistory(req GetEnvVersionsRequest) {
+	path := filepath.Join(b.Dir, b.historyName(req)+".json")
+
+	versions, err := b.Backend.GetEnvVersions(req)
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(versions); err == nil {
+			err = b.store(path, data)
+		}
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+}
+
+func (b *CachingBackend) historyName(req GetEnvVersionsRequest) string {
+	return filepath.Join("envs", req.ProjectId.String(), pathKey(req.EnvName))
+}
+
+func (b *CachingBackend) GetEnv(req GetEnvRequest) (*GetEnvResponse, error) {
+	var env GetEnvResponse
+	name := fmt.Sprintf("%s@%d", pathKey(req.EnvName), req.Version)
+	err := b.read(filepath.Join("envs", req.ProjectId.String(), name), fmt.Sprintf("version %d of %s", req.Version, req.EnvName), &env, func() (any, error) {
+		return b.Backend.GetEnv(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &env, nil
+}
+
+func (b *CachingBackend) GetEnvVersions(req GetEnvVersionsRequest) ([]EnvResponse, error) {
+	var versions []EnvResponse
+	err := b.read(b.historyName(req), "history of "+req.EnvName, &versions, func() (any, error) {
+		return b.Backend.GetEnvVersions(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// read fetches a response and caches it, or decodes the cached copy into v
+// when offline or when fetch fails because the backend is unreachable.
+func (b *CachingBackend) read(name, what string, v any, fetch func() (any, error)) error {
+	path := filepath.Join(b.Dir, name+".json")
+
+	if !b.Offline && !b.down {
+		resp, err := fetch()
+		if err == nil {
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return err
+			}
+			if err := b.store(path, data); err != nil {
+				b.warn("warning: caching %s failed: %v", what, err)
+			}
+			return json.Unmarshal(data, v)
+		}
+		if !unreachable(err) {
+			return err
+		}
+		b.warn("warning: server unreachable, falling back to the local cache: %v", err)
+		b.down = true
+	}
+
+	var entry cacheEntry
+	if err := readJSON(path, &entry); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s is not cached: %w", what, ErrOffline)
+		}
+		return err
+	}
+
+	age := time.Since(entry.FetchedAt)
+	if b.MaxAge > 0 && age > b.MaxAge {
+		os.Remove(path)
+		return fmt.Errorf("cached %s expired %s ago: %w", what, formatAge(age-b.MaxAge), ErrOffline)
+	}
+
+	if b.StaleAfter > 0 && age > b.StaleAfter {
+		b.warn("warning: using STALE cached %s from %s ago", what, formatAge(age))
+	} else {
+		b.warn("warning: using cached %s from %s ago", what, formatAge(age))
+	}
+
+	return json.Unmarshal(entry.Data, v)
+}
+
+func (b *CachingBackend) store(path string, data []byte) error {
+	entry, err := json.Marshal(cacheEntry{FetchedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, entry, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if b.evicted {
+		return nil
+	}
+	b.evicted = true
+	return b.evict()
+}
+
+// evict removes entries that were not refreshed within MaxAge.
+func (b *CachingBackend) evict() error {
+	if b.MaxAge <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-b.MaxAge)
+	return filepath.WalkDir(b.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (b *CachingBackend) warn(format string, args ...any) {
+	w := b.Warnings
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format+"\n", args...)
+}
+
+// unreachable reports whether err means the backend could not be reached at
+// all, as opposed to it rejecting the request.
+func unreachable(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathKey turns a user supplied name into a safe file name.
+func pathKey(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:16])
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return d.Round(time.Second).String()
+	case d < 48*time.Hour:
+		return d.Round(time.Minute).String()
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// ChainProblem is one inconsistency found while verifying the hash chain of
+// an environment.
+type ChainProblem struct {
+	Version int32
+	Kind    string // gap, fork, relabelled, replayed, downgrade, rollback, forged, unsigned or unverified
+	Detail  string
+}
+
+// ChainError means the versions returned by the backend were dropped,
+// reordered, replayed, rolled back or not signed by their claimed author.
+type ChainError struct {
+	EnvName  string
+	Problems []ChainProblem
+}
+
+func (e *ChainError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "history of %s failed verification:", e.EnvName)
+	acceptable := false
+	for _, problem := range e.Problems {
+		fmt.Fprintf(&b, "\n  version %d: %s: %s", problem.Version, problem.Kind, problem.Detail)
+		acceptable = acceptable || acceptableKind(problem.Kind)
+	}
+	if acceptable {
+		b.WriteString("\nIf the author confirms pushing an unsigned or unverified version, run `env verify -accept VERSION`.")
+	}
+
+	return b.String()
+}
+
+// chainPin remembers the newest version this client has seen, so a backend
+// serving an older history is caught even if that history is consistent.
+type chainPin struct {
+	Version int32  `json:"version"`
+	Hash    []byte `json:"hash"`
+	// Accepted holds the hashes of the versions whose missing or
+	// unverifiable signature was accepted with AcceptVersion.
+	Accepted map[int32][]byte `json:"accepted,omitempty"`
+}
+
+func verifyChain(projectId uuid.UUID, envName string, history []EnvVersion, payloads []*cryptutils.EnvPayload) error {
+	var problems []ChainProblem
+	report := func(version int32, kind, format string, args ...any) {
+		problems = append(problems, ChainProblem{Version: version, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	chained := false
+	var previous int32
+	for i, envVersion := range history {
+		payload := payloads[i]
+
+		if envVersion.Version != previous+1 {
+			report(envVersion.Version, "gap", "follows version %d", previous)
+		}
+		previous = envVersion.Version
+
+		if !payload.Chained {
+			if chained {
+				report(envVersion.Version, "downgrade", "version has no chain header although earlier ones do")
+			}
+			continue
+		}
+		chained = true
+
+		if payload.Version != envVersion.Version {
+			report(envVersion.Version, "relabelled", "ciphertext was pushed as version %d", payload.Version)
+		}
+		if payload.EnvName != envName {
+			report(envVersion.Version, "replayed", "ciphertext was pushed to env %q", payload.EnvName)
+		}
+
+		switch {
+		case i == 0 && len(payload.PrevHash) != 0:
+			report(envVersion.Version, "gap", "commits to a previous version that was not returned")
+		case i > 0 && !bytes.Equal(payload.PrevHash, history[i-1].Hash):
+			report(envVersion.Version, "fork", "does not commit to version %d as returned", history[i-1].Version)
+		}
+	}
+
+	pin, err := loadChainPin(projectId, envName)
+	if err != nil {
+		return err
+	}
+
+	var accepted map[int32][]byte
+	if pin != nil {
+		accepted = pin.Accepted
+	}
+	problems = append(problems, verifySignatures(projectId, envName, history, accepted)...)
+
+	latest := latestVersion(history)
+	if pin != nil {
+		if latest.versionOrZero() < pin.Version {
+			report(latest.versionOrZero(), "rollback", "version %d was seen before, the backend is serving an older history", pin.Version)
+		}
+		for _, envVersion := range history {
+			if envVersion.Version == pin.Version && !bytes.Equal(envVersion.Hash, pin.Hash) {
+				report(pin.Version, "fork", "differs from the version %d seen before", pin.Version)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ChainError{EnvName: envName, Problems: problems}
+	}
+
+	if latest == nil {
+		return nil
+	}
+
+	return pinChain(projectId, envName, latest.Version, latest.Hash)
+}
+
+func chainPinPath(projectId uuid.UUID, envName string) (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "chains", projectId.String(), url.PathEscape(envName)+".json"), nil
+}
+
+func loadChainPin(projectId uuid.UUID, envName string) (*chainPin, error) {
+	path, err := chainPinPath(projectId, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pin chainPin
+	if err := readJSON(path, &pin); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &pin, nil
+}
+
+// pinChain records version as the newest one seen, unless a newer one is
+// already pinned.
+func pinChain(projectId uuid.UUID, envName string, version int32, hash []byte) error {
+	current, err := loadChainPin(projectId, envName)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Version > version {
+		return nil
+	}
+
+	pin := &chainPin{Version: version, Hash: hash}
+	if current != nil {
+		pin.Accepted = current.Accepted
+	}
+
+	return saveChainPin(projectId, envName, pin)
+}
+
+func saveChainPin(projectId uuid.UUID, envName string, pin *chainPin) error {
+	path, err := chainPinPath(projectId, envName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(pin)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
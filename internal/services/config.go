@@ -0,0 +1,21 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConfigDir is where the client keeps local state such as pinned hash
+// chains. ENVCRYPT_CONFIG_DIR overrides the per-user default.
+func ConfigDir() (string, error) {
+	if dir := os.Getenv("ENVCRYPT_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(base, "envcrypt"), nil
+}
@@ -0,0 +1,476 @@
+package services_test
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"testing"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/devserver/devservertest"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+	"github.com/google/uuid"
+)
+
+const (
+	testEmail    = "alice@example.com"
+	testPassword = "correct horse battery staple"
+	testProject  = "shop"
+	testEnv      = "Production"
+)
+
+type testSession struct {
+	keyPair    *cryptutils.KeyPair
+	projectId  uuid.UUID
+	wrappedKey *cryptutils.WrappedKey
+}
+
+// startSession registers a user against a fresh dev server, logs in and
+// creates a project, with the config dir and schema lookup kept out of the
+// user's home.
+func startSession(t *testing.T) *testSession {
+	t.Helper()
+
+	t.Setenv("ENVCRYPT_CONFIG_DIR", t.TempDir())
+	t.Setenv("ENVCRYPT_SCHEMA", "none")
+	devservertest.Start(t)
+
+	if err := services.Register(testEmail, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	keyPair, userId, err := services.Login(testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		services.ForgetSigningKey(testEmail)
+		keyPair.Destroy()
+	})
+
+	if err := services.CreateProject(testProject, *userId, keyPair.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	wrappedKey, projectId, err := services.GetProject(testProject, *userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSession{keyPair: keyPair, projectId: *projectId, wrappedKey: wrappedKey}
+}
+
+func (s *testSession) set(t *testing.T, key, value string) {
+	t.Helper()
+	if err := services.SetEnvKey(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, key, value, time.Time{}, s.wrappedKey, ""); err != nil {
+		t.Fatalf("set %s: %v", key, err)
+	}
+}
+
+func (s *testSession) history(t *testing.T) ([]services.EnvVersion, error) {
+	t.Helper()
+	return services.PullEnvHistory(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, s.wrappedKey)
+}
+
+func TestEndToEnd(t *testing.T) {
+	s := startSession(t)
+
+	s.set(t, "DATABASE_URL", "postgres://db/shop")
+	s.set(t, "API_KEY", "first")
+	s.set(t, "API_KEY", "second")
+
+	history, err := s.history(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("got %d versions, want 3", len(history))
+	}
+	for _, v := range history {
+		if !v.Signed {
+			t.Errorf("version %d is not signed", v.Version)
+		}
+		if v.PushedAt.IsZero() {
+			t.Errorf("version %d has no signed timestamp", v.Version)
+		}
+		if v.Metadata.Author != testEmail {
+			t.Errorf("version %d: author %q, want %q", v.Version, v.Metadata.Author, testEmail)
+		}
+	}
+	if got := history[2].Metadata.Keys; len(got) != 1 || got[0] != "API_KEY" {
+		t.Errorf("version 3 changed %v, want [API_KEY]", got)
+	}
+
+	value, err := services.GetEnvKey(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, "API_KEY", s.wrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "second" {
+		t.Errorf("API_KEY = %q, want second", value)
+	}
+
+	if err := services.RollbackEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, 2, s.wrappedKey, services.RollbackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	env, version, err := services.PullLatestEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, s.wrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"DATABASE_URL": "postgres://db/shop", "API_KEY": "first"}
+	if version != 4 || !maps.Equal(env, want) {
+		t.Errorf("after rollback got version %d %v, want version 4 %v", version, env, want)
+	}
+
+	history, err = s.history(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := history[3].Metadata; m.Type != "env_rollback" || m.SourceVersion != 2 {
+		t.Errorf("version 4 is %s of version %d, want env_rollback of version 2", m.Type, m.SourceVersion)
+	}
+
+	err = services.RollbackEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, 4, s.wrappedKey, services.RollbackOptions{})
+	if !errors.Is(err, services.ErrNothingToRollback) {
+		t.Errorf("rollback to the latest version: got %v, want ErrNothingToRollback", err)
+	}
+
+	plan, err := services.PlanRollback(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, 3, s.wrappedKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.set(t, "API_KEY", "third")
+	err = services.PushRollbackEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, plan.Result, plan.KeyInfo, s.wrappedKey, plan.SourceVersion, plan.LatestVersion, "")
+	if err == nil {
+		t.Error("rollback planned before another push was pushed on top of it")
+	}
+}
+
+// tamperBackend serves the versions of the wrapped backend after passing
+// them through tamper, like a malicious server would.
+type tamperBackend struct {
+	services.Backend
+	tamper func([]services.EnvResponse) []services.EnvResponse
+}
+
+func (b *tamperBackend) GetEnvVersions(req services.GetEnvVersionsRequest) ([]services.EnvResponse, error) {
+	versions, err := b.Backend.GetEnvVersions(req)
+	if err != nil {
+		return nil, err
+	}
+	return b.tamper(versions), nil
+}
+
+func TestEndToEndTamper(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]services.EnvResponse) []services.EnvResponse
+		// kind is the expected ChainProblem, empty when decryption itself
+		// must fail.
+		kind string
+	}{
+		{
+			name: "dropped latest version",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				return v[:len(v)-1]
+			},
+			kind: "rollback",
+		},
+		{
+			name: "dropped middle version",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				return append(v[:1:1], v[2:]...)
+			},
+			kind: "gap",
+		},
+		{
+			name: "swapped ciphertexts",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[1].CipherText, v[2].CipherText = v[2].CipherText, v[1].CipherText
+				v[1].Nonce, v[2].Nonce = v[2].Nonce, v[1].Nonce
+				return v
+			},
+			kind: "relabelled",
+		},
+		{
+			name: "signature of another version",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[2].Metadata.Signature = v[1].Metadata.Signature
+				return v
+			},
+			kind: "forged",
+		},
+		{
+			name: "signature removed",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[2].Metadata.Signature = nil
+				return v
+			},
+			kind: "unsigned",
+		},
+		{
+			name: "every signature removed",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				for i := range v {
+					v[i].Metadata.Signature = nil
+				}
+				return v
+			},
+			kind: "unsigned",
+		},
+		{
+			name: "author removed",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[0].Metadata.Author = ""
+				v[0].Metadata.Signature = nil
+				return v
+			},
+			kind: "unsigned",
+		},
+		{
+			name: "unknown author",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[2].Metadata.Author = "mallory@example.com"
+				return v
+			},
+			kind: "unverified",
+		},
+		{
+			name: "flipped ciphertext bit",
+			tamper: func(v []services.EnvResponse) []services.EnvResponse {
+				v[1].CipherText[0] ^= 1
+				return v
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startSession(t)
+			s.set(t, "A", "1")
+			s.set(t, "B", "2")
+			s.set(t, "C", "3")
+
+			// Pulling pins the untampered history.
+			if _, err := s.history(t); err != nil {
+				t.Fatal(err)
+			}
+
+			services.DefaultBackend = &tamperBackend{Backend: services.DefaultBackend, tamper: tt.tamper}
+
+			_, err := s.history(t)
+			if err == nil {
+				t.Fatal("tampered history was accepted")
+			}
+			var chainErr *services.ChainError
+			if tt.kind == "" {
+				if errors.As(err, &chainErr) {
+					t.Fatalf("got chain error %v, want a decryption error", err)
+				}
+				return
+			}
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("got %v, want a *ChainError", err)
+			}
+			for _, problem := range chainErr.Problems {
+				if problem.Kind == tt.kind {
+					return
+				}
+			}
+			t.Errorf("got %v, want a %s problem", err, tt.kind)
+		})
+	}
+}
+
+func TestEndToEndAcceptUnsignedVersion(t *testing.T) {
+	s := startSession(t)
+	s.set(t, "A", "1")
+
+	// Like a client that pushes without the author's signing key.
+	services.ForgetSigningKey(testEmail)
+	s.set(t, "A", "2")
+
+	var chainErr *services.ChainError
+	if _, err := s.history(t); !errors.As(err, &chainErr) {
+		t.Fatalf("got %v, want the unsigned version reported", err)
+	}
+
+	if err := services.AcceptVersion(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, 1, s.wrappedKey); err == nil {
+		t.Error("accepted a signed version")
+	}
+	if err := services.AcceptVersion(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, 2, s.wrappedKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.history(t); err != nil {
+		t.Fatalf("after accepting version 2: %v", err)
+	}
+
+	keyPair, _, err := services.Login(testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyPair.Destroy()
+	s.set(t, "A", "3")
+
+	history, err := s.history(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[1].Signed || !history[2].Signed {
+		t.Errorf("got %d versions, want 3 with only version 2 unsigned", len(history))
+	}
+}
+
+func TestEndToEndOfflineAfterPush(t *testing.T) {
+	s := startSession(t)
+
+	cached, err := services.NewCachingBackend(services.DefaultBackend, "e2e", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Warnings = io.Discard
+	services.DefaultBackend = cached
+
+	s.set(t, "A", "1")
+	s.set(t, "B", "2")
+
+	cached.Offline = true
+	history, err := s.history(t)
+	if err != nil {
+		t.Fatalf("offline pull after push: %v", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("got %d cached versions, want 2", len(history))
+	}
+}
+
+// renamingBackend answers logins with the account renamed to email.
+type renamingBackend struct {
+	services.Backend
+	email string
+}
+
+func (b *renamingBackend) Login(req services.LoginRequestBody) (*services.UserBody, error) {
+	user, err := b.Backend.Login(req)
+	if err != nil {
+		return nil, err
+	}
+	user.Email = b.email
+	return user, nil
+}
+
+func TestEndToEndLoginOtherAccount(t *testing.T) {
+	startSession(t)
+	services.ForgetSigningKey(testEmail)
+
+	services.DefaultBackend = &renamingBackend{Backend: services.DefaultBackend, email: "bob@example.com"}
+
+	if keyPair, _, err := services.Login(testEmail, testPassword); err == nil {
+		keyPair.Destroy()
+		t.Fatal("login accepted the account of another email")
+	}
+}
+
+func TestEndToEndExpiryFollowsValue(t *testing.T) {
+	s := startSession(t)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	latest := func() *services.EnvVersion {
+		t.Helper()
+		v, err := services.PullLatestVersion(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, s.wrappedKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	setExpiring := func(key, value string) {
+		t.Helper()
+		if err := services.SetEnvKey(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, key, value, expires, s.wrappedKey, ""); err != nil {
+			t.Fatal(err)
+		}
+		err := services.UpdateKeyInfo(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, key, s.wrappedKey, services.Metadata{Type: "env_key_described"}, func(info *cryptutils.KeyInfo) {
+			info.Owner = "ops"
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(step, key string, wantExpires bool) {
+		t.Helper()
+		info := latest().KeyInfo[key]
+		if got := !info.Expires.IsZero(); got != wantExpires {
+			t.Errorf("%s: %s expires %v, want expiry %v", step, key, info.Expires, wantExpires)
+		}
+		if info.Owner != "ops" {
+			t.Errorf("%s: %s lost its owner", step, key)
+		}
+	}
+
+	setExpiring("A", "1")
+	s.set(t, "A", "1")
+	check("set to the same value", "A", true)
+	s.set(t, "A", "2")
+	check("set to a new value", "A", false)
+
+	setExpiring("B", "1")
+	setExpiring("C", "1")
+	v := latest()
+	env := maps.Clone(v.Env)
+	env["B"] = "2"
+	env["D"] = "new"
+	if err := services.ReplaceEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, env, v.Version, s.wrappedKey, services.Metadata{Type: "env_edited"}); err != nil {
+		t.Fatal(err)
+	}
+	check("replaced with a new value", "B", false)
+	check("replaced unchanged", "C", true)
+
+	policies := map[string]cryptutils.SecretPolicy{"C": {Type: "hex"}}
+	if _, err := services.GenerateEnvKeys(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, policies, true, s.wrappedKey, ""); err != nil {
+		t.Fatal(err)
+	}
+	check("generated", "C", false)
+}
+
+func TestEndToEndRollbackKeyInfo(t *testing.T) {
+	s := startSession(t)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	describe := func(description string) {
+		t.Helper()
+		err := services.UpdateKeyInfo(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, "A", s.wrappedKey, services.Metadata{Type: "env_key_described"}, func(info *cryptutils.KeyInfo) {
+			info.Owner = "ops"
+			info.Description = description
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rollback := func(version int32, keys []string) cryptutils.KeyInfo {
+		t.Helper()
+		if err := services.RollbackEnv(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, version, s.wrappedKey, services.RollbackOptions{Keys: keys}); err != nil {
+			t.Fatal(err)
+		}
+		v, err := services.PullLatestVersion(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, s.wrappedKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Env["A"] != "1" {
+			t.Errorf("rolled back to A=%s, want 1", v.Env["A"])
+		}
+		return v.KeyInfo["A"]
+	}
+
+	if err := services.SetEnvKey(s.projectId, testEmail, s.keyPair.PrivateKey, testEnv, "A", "1", expires, s.wrappedKey, ""); err != nil {
+		t.Fatal(err)
+	}
+	describe("old")
+	s.set(t, "A", "2")
+	describe("new")
+
+	for _, keys := range [][]string{nil, {"A"}} {
+		info := rollback(2, keys)
+		if !info.Expires.Equal(expires) || info.Owner != "ops" || info.Description != "new" {
+			t.Errorf("rollback of %v: got %+v, want the expiry of version 2 and the latest description", keys, info)
+		}
+		s.set(t, "A", "2")
+	}
+}
@@ -1,20 +1,19 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"sort"
+	"time"
 
 	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
 	"github.com/google/uuid"
 )
 
-type Metadata struct {
-	Type string `json:"type"`
-}
+const DefaultEnvName = "Testing"
+
 type AddEnvRequest struct {
 	ProjectId uuid.UUID `json:"project_id"`
 	Email     string    `json:"user_email"`
@@ -26,7 +25,7 @@ type AddEnvRequest struct {
 	Metadata Metadata `json:"metadata"`
 }
 
-func PushEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey) error {
+func PushEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, message string) error {
 
 	// compress the file
 	fileData, err := os.ReadFile("/home/vijay/Projects/encrypt-cli/key.txt")
@@ -34,57 +33,23 @@ func PushEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *c
 		return err
 	}
 
-	data, err := cryptutils.PrepareEnvForStorage(fileData)
-	if err != nil {
-		return err
-	}
-
-	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
+	parsedEnv, err := cryptutils.ParseEnv(fileData)
 	if err != nil {
-		log.Println("Pmk not unwrapped")
 		return err
 	}
 
-	// encrypt using pmk and store the nonce, ciphertext
-	encryptedData, nonce, err := cryptutils.EncryptENV(pmk, data)
+	history, err := PullEnvHistory(projectId, email, privateKey, DefaultEnvName, wrappedKey)
 	if err != nil {
-		log.Println("not encruypt")
 		return err
 	}
 
 	metadata := Metadata{
-		Type: "env_created",
-	}
-
-	var AddEnvRequest AddEnvRequest = AddEnvRequest{
-		ProjectId:  projectId,
-		Email:      email,
-		EnvName:    "Testing",
-		CipherText: encryptedData,
-		Nonce:      nonce,
-		Metadata:   metadata,
-	}
-	requestBody, err := json.Marshal(AddEnvRequest)
-	if err != nil {
-		return err
+		Type:    "env_created",
+		Keys:    DiffKeys(nil, parsedEnv),
+		Message: message,
 	}
 
-	// send to server
-	resp, err := http.Post("http://localhost:8080/env/create", "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(body))
-		return nil
-	}
-
-	log.Println(resp.Body)
-
-	return nil
+	return pushEnvVersion(projectId, email, privateKey, DefaultEnvName, parsedEnv, wrappedKey, metadata, latestVersion(history))
 }
 
 type GetEnvRequest struct {
@@ -100,52 +65,22 @@ type GetEnvResponse struct {
 	Nonce      []byte `json:"nonce"`
 }
 
+// PullEnv returns a single version. The whole history is fetched so the
+// version can be checked against the hash chain.
 func PullEnv(projectId uuid.UUID, email string, privateKey []byte, version int32, wrappedKey *cryptutils.WrappedKey) (map[string]string, error) {
 
-	var requestBody GetEnvRequest = GetEnvRequest{
-		ProjectId: projectId,
-		Email:     email,
-		EnvName:   "Testing",
-		Version:   version,
-	}
-	requestBodyBytes, err := json.Marshal(requestBody)
+	history, err := PullEnvHistory(projectId, email, privateKey, DefaultEnvName, wrappedKey)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Post("http://localhost:8080/env/search", "application/json", bytes.NewBuffer(requestBodyBytes))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var responseBody GetEnvResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
-	if err != nil {
-		log.Println("Error pushing env", err.Error())
-	}
-
-	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
-	if err != nil {
-		log.Println("Pmk not unwrapped")
-		return nil, err
-	}
-
-	decryptedData, err := cryptutils.DecryptENV(pmk, responseBody.CipherText, responseBody.Nonce)
-	if err != nil {
-		log.Println("not decrypt")
-	}
-
-	parsedEnv, err := cryptutils.ReadEnvFromStorage(decryptedData)
-	if err != nil {
-		return nil, err
-	}
-
-	for k, v := range parsedEnv {
-		log.Println(k, v)
+	for _, envVersion := range history {
+		if envVersion.Version == version {
+			return envVersion.Env, nil
+		}
 	}
 
-	return parsedEnv, nil
+	return nil, fmt.Errorf("version %d of %s does not exist", version, DefaultEnvName)
 }
 
 type UpdateEnvRequest struct {
@@ -163,7 +98,7 @@ type UpdateEnvResponse struct {
 	Message string `json:"message"`
 }
 
-func UpdateEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey) error {
+func UpdateEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, message string) error {
 
 	// compress the file
 	fileData, err := os.ReadFile("/home/vijay/Projects/encrypt-cli/key.txt")
@@ -171,54 +106,24 @@ func UpdateEnv(projectId uuid.UUID, email string, privateKey []byte, wrappedKey
 		return err
 	}
 
-	data, err := cryptutils.PrepareEnvForStorage(fileData)
-	if err != nil {
-		return err
-	}
-
-	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
+	parsedEnv, err := cryptutils.ParseEnv(fileData)
 	if err != nil {
-		log.Println("Pmk not unwrapped")
 		return err
 	}
 
-	// encrypt using pmk and store the nonce, ciphertext
-	encryptedData, nonce, err := cryptutils.EncryptENV(pmk, data)
+	history, err := PullEnvHistory(projectId, email, privateKey, DefaultEnvName, wrappedKey)
 	if err != nil {
-		log.Println("not encrypt")
 		return err
 	}
+	latest := latestVersion(history)
 
 	metadata := Metadata{
-		Type: "env_updated",
-	}
-	var updateEnvRequest UpdateEnvRequest = UpdateEnvRequest{
-		ProjectId:  projectId,
-		Email:      email,
-		EnvName:    "Testing",
-		CipherText: encryptedData,
-		Nonce:      nonce,
-		Metadata:   metadata,
-	}
-	requestBody, err := json.Marshal(updateEnvRequest)
-	if err != nil {
-		return err
-	}
-
-	// send to server
-	resp, err := http.Post("http://localhost:8080/env/update", "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(body))
-		return nil
+		Type:    "env_updated",
+		Keys:    DiffKeys(latest.envOrNil(), parsedEnv),
+		Message: message,
 	}
 
-	return nil
+	return pushEnvVersion(projectId, email, privateKey, DefaultEnvName, parsedEnv, wrappedKey, metadata, latest)
 }
 
 type GetEnvVersionsRequest struct {
@@ -238,145 +143,388 @@ type GetEnvVersionsResponse struct {
 	EnvVersions []EnvResponse `json:"env_versions"`
 }
 
-func GetEnvVersions(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey) error {
+// GetEnvVersions prints the metadata of every version of an environment,
+// oldest first, and returns any problem found in the hash chain.
+func GetEnvVersions(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey) error {
 
-	var requestBody GetEnvVersionsRequest = GetEnvVersionsRequest{
-		ProjectId: projectId,
-		Email:     email,
-		EnvName:   "Testing",
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
+
+	for _, envVersion := range history {
+		WriteVersionLog(os.Stdout, envVersion, nil)
 	}
 
-	requestBodyBytes, err := json.Marshal(requestBody)
+	return err
+}
+
+func DiffENVVersions(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, oldVersion, newVersion int32) error {
+
+	oldVersionEnv, err := PullEnv(projectId, email, privateKey, oldVersion, wrappedKey)
 	if err != nil {
 		return err
 	}
+	newVersionEnv, err := PullEnv(projectId, email, privateKey, newVersion, wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	diffingResult := cryptutils.DiffEnvVersions(oldVersionEnv, newVersionEnv)
+	log.Println("ADDED", diffingResult.Added)
+	log.Println("Modified", diffingResult.Modified)
+	log.Println("Removed", diffingResult.Removed)
+
+	return nil
+}
+
+// PushRollbackEnv pushes env as the version following baseVersion, which
+// restores sourceVersion. Like ReplaceEnv it fails if somebody else pushed
+// since the rollback was planned.
+func PushRollbackEnv(projectId uuid.UUID, email string, privateKey []byte, envName string, env map[string]string, info map[string]cryptutils.KeyInfo, wrappedKey *cryptutils.WrappedKey, sourceVersion, baseVersion int32, message string) error {
 
-	resp, err := http.Post("http://localhost:8080/env/search/all", "application/json", bytes.NewBuffer(requestBodyBytes))
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(body))
-		return nil
+	latest := latestVersion(history)
+	if latest.versionOrZero() != baseVersion {
+		return fmt.Errorf("%s changed to version %d while rolling back version %d", envName, latest.versionOrZero(), baseVersion)
+	}
+
+	metadata := Metadata{
+		Type:          "env_rollback",
+		Keys:          DiffKeys(latest.envOrNil(), env),
+		Message:       message,
+		SourceVersion: sourceVersion,
+	}
+
+	return pushEnvVersionInfo(projectId, email, privateKey, envName, env, info, wrappedKey, metadata, latest)
+}
+
+func fetchEnvVersions(projectId uuid.UUID, email, envName string) ([]EnvResponse, error) {
+
+	var requestBody GetEnvVersionsRequest = GetEnvVersionsRequest{
+		ProjectId: projectId,
+		Email:     email,
+		EnvName:   envName,
 	}
 
-	var responseBody GetEnvVersionsResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
+	return DefaultBackend.GetEnvVersions(requestBody)
+}
+
+// PullLatestVersion returns the newest version of an environment, or nil if
+// none was pushed yet.
+func PullLatestVersion(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey) (*EnvVersion, error) {
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
-		log.Println("Error pushing env", err.Error())
+		return nil, err
 	}
 
-	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
+	return latestVersion(history), nil
+}
+
+// PullLatestEnv returns the newest version of an environment along with its
+// version number. A project without any pushed version yields an empty map
+// and version 0.
+func PullLatestEnv(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey) (map[string]string, int32, error) {
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
-		log.Println("Pmk not unwrapped")
-		return err
+		return nil, 0, err
 	}
 
-	for _, envVersion := range responseBody.EnvVersions {
-		decryptedData, err := cryptutils.DecryptENV(pmk, envVersion.CipherText, envVersion.Nonce)
-		if err != nil {
-			log.Println("not decrypt")
-		}
+	latest := latestVersion(history)
+	if latest == nil {
+		return map[string]string{}, 0, nil
+	}
 
-		readableData, err := cryptutils.ReadEnvFromStorage(decryptedData)
-		if err != nil {
-			log.Println("not readable")
-		}
+	return latest.Env, latest.Version, nil
+}
 
-		log.Printf("%d : %s -> %v", envVersion.Version, readableData, envVersion.Metadata)
+// pushEnvVersion encrypts env with the project key and stores it as the
+// version following prev, committing to prev's hash. The first version of an
+// environment (prev == nil) goes through CreateEnv, every later one through
+// UpdateEnv. The key info of prev carries over, except for the expiry of
+// values that changed.
+func pushEnvVersion(projectId uuid.UUID, email string, privateKey []byte, envName string, env map[string]string, wrappedKey *cryptutils.WrappedKey, metadata Metadata, prev *EnvVersion) error {
+	info := make(map[string]cryptutils.KeyInfo)
+	for key, ki := range prev.keyInfoOrNil() {
+		if value, exists := env[key]; exists && value != prev.Env[key] {
+			ki.Expires = time.Time{}
+		}
+		info[key] = ki
 	}
 
-	return nil
+	return pushEnvVersionInfo(projectId, email, privateKey, envName, env, info, wrappedKey, metadata, prev)
 }
 
-func DiffENVVersions(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, oldVersion, newVersion int32) error {
+// pushEnvVersionInfo is pushEnvVersion with the key info of the new version.
+// Info about keys that are not in env is dropped.
+func pushEnvVersionInfo(projectId uuid.UUID, email string, privateKey []byte, envName string, env map[string]string, info map[string]cryptutils.KeyInfo, wrappedKey *cryptutils.WrappedKey, metadata Metadata, prev *EnvVersion) error {
 
-	oldVersionEnv, err := PullEnv(projectId, email, privateKey, oldVersion, wrappedKey)
-	if err != nil {
+	if err := checkSchema(envName, env, metadata.Type == "env_rollback"); err != nil {
 		return err
 	}
-	newVersionEnv, err := PullEnv(projectId, email, privateKey, newVersion, wrappedKey)
+
+	keyInfo := make(map[string]cryptutils.KeyInfo)
+	for key, ki := range info {
+		if _, exists := env[key]; exists && !ki.IsZero() {
+			keyInfo[key] = ki
+		}
+	}
+
+	data, err := cryptutils.PrepareEnvForRollback(env)
 	if err != nil {
 		return err
 	}
 
-	diffingResult := cryptutils.DiffEnvVersions(oldVersionEnv, newVersionEnv)
-	log.Println("ADDED", diffingResult.Added)
-	log.Println("Modified", diffingResult.Modified)
-	log.Println("Removed", diffingResult.Removed)
+	metadata.stamp(email)
 
-	return nil
-}
+	payload := &cryptutils.EnvPayload{
+		EnvName: envName,
+		Version: 1,
+		Env:     data,
+		KeyInfo: keyInfo,
+		Padding: cryptutils.DefaultPadding,
 
-func PushRollbackEnv(projectId uuid.UUID, email string, privateKey []byte, env map[string]string, wrappedKey *cryptutils.WrappedKey) error {
+		Keys:      metadata.Keys,
+		Generated: metadata.Generated,
+		Timestamp: metadata.Timestamp,
+	}
+	metadata.Keys, metadata.Generated = nil, nil
+	if prev != nil {
+		payload.Version = prev.Version + 1
+		payload.PrevHash = prev.Hash
+	}
 
-	// prepare the env
-	data, err := cryptutils.PrepareEnvForRollback(env)
+	plaintext, err := cryptutils.EncodeEnvPayload(payload)
 	if err != nil {
 		return err
 	}
 
 	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
 	if err != nil {
-		log.Println("Pmk not unwrapped")
 		return err
 	}
+	defer pmk.Destroy()
 
-	// encrypt using pmk and store the nonce, ciphertext
-	encryptedData, nonce, err := cryptutils.EncryptENV(pmk, data)
+	encryptedData, nonce, err := cryptutils.EncryptENV(pmk.Bytes(), plaintext)
+	clear(plaintext)
+	clear(data)
 	if err != nil {
-		log.Println("not encruypt")
 		return err
 	}
 
-	metadata := Metadata{
-		Type: "env_rollback",
+	versionHash := cryptutils.VersionHash(encryptedData, nonce)
+	metadata.Signature, err = signVersion(projectId, envName, email, versionHash)
+	if err != nil {
+		return err
 	}
 
-	var AddEnvRequest AddEnvRequest = AddEnvRequest{
-		ProjectId:  projectId,
-		Email:      email,
-		EnvName:    "Testing",
-		CipherText: encryptedData,
-		Nonce:      nonce,
-		Metadata:   metadata,
+	if prev == nil {
+		err = DefaultBackend.CreateEnv(AddEnvRequest{
+			ProjectId:  projectId,
+			Email:      email,
+			EnvName:    envName,
+			CipherText: encryptedData,
+			Nonce:      nonce,
+			Metadata:   metadata,
+		})
+	} else {
+		err = DefaultBackend.UpdateEnv(UpdateEnvRequest{
+			ProjectId:  projectId,
+			Email:      email,
+			EnvName:    envName,
+			CipherText: encryptedData,
+			Nonce:      nonce,
+			Metadata:   metadata,
+		})
 	}
-	requestBody, err := json.Marshal(AddEnvRequest)
 	if err != nil {
 		return err
 	}
 
-	// send to server
-	resp, err := http.Post("http://localhost:8080/env/create", "application/json", bytes.NewBuffer(requestBody))
+	return pinChain(projectId, envName, payload.Version, versionHash)
+}
+
+// errNoChange lets the change passed to modifyLatestEnv skip the push.
+var errNoChange = errors.New("nothing to change")
+
+// modifyLatestEnv pulls the latest version, lets change edit its values and
+// key info in place and pushes the result as a new version. change may also
+// fill in metadata, or return errNoChange to push nothing.
+func modifyLatestEnv(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey, metadata *Metadata, change func(env map[string]string, info map[string]cryptutils.KeyInfo) error) error {
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	latest := latestVersion(history)
+
+	env := make(map[string]string)
+	for key, value := range latest.envOrNil() {
+		env[key] = value
+	}
+	info := make(map[string]cryptutils.KeyInfo)
+	for key, ki := range latest.keyInfoOrNil() {
+		info[key] = ki
+	}
+
+	if err := change(env, info); err != nil {
+		if errors.Is(err, errNoChange) {
+			return nil
+		}
+		return err
+	}
+
+	return pushEnvVersionInfo(projectId, email, privateKey, envName, env, info, wrappedKey, *metadata, latest)
+}
+
+// SetEnvKey sets key to value. A non-zero expires sets when the value
+// expires. Otherwise the expiry is kept if the value stays the same and
+// cleared if it changes, as it belonged to the old value.
+func SetEnvKey(projectId uuid.UUID, email string, privateKey []byte, envName, key, value string, expires time.Time, wrappedKey *cryptutils.WrappedKey, message string) error {
+
+	if err := cryptutils.ValidateEnvEntry(key, value); err != nil {
+		return err
+	}
+
+	metadata := Metadata{
+		Type:    "env_key_set",
+		Keys:    []string{key},
+		Message: message,
+	}
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(body))
+	return modifyLatestEnv(projectId, email, privateKey, envName, wrappedKey, &metadata, func(env map[string]string, info map[string]cryptutils.KeyInfo) error {
+		if old, exists := env[key]; !exists || old != value || !expires.IsZero() {
+			ki := info[key]
+			ki.Expires = expires
+			info[key] = ki
+		}
+		env[key] = value
 		return nil
+	})
+}
+
+func UnsetEnvKey(projectId uuid.UUID, email string, privateKey []byte, envName, key string, wrappedKey *cryptutils.WrappedKey, message string) error {
+
+	metadata := Metadata{
+		Type:    "env_key_unset",
+		Keys:    []string{key},
+		Message: message,
 	}
 
-	log.Println(resp.Body)
+	return modifyLatestEnv(projectId, email, privateKey, envName, wrappedKey, &metadata, func(env map[string]string, _ map[string]cryptutils.KeyInfo) error {
+		if _, exists := env[key]; !exists {
+			return fmt.Errorf("key %s is not set", key)
+		}
+		delete(env, key)
+		return nil
+	})
+}
+
+// GenerateEnvKeys sets each key in policies to a newly generated value and
+// pushes the result as a single version. Keys that are already set are left
+// alone unless overwrite is set. It returns the generated values, which are
+// empty if every key was already set.
+func GenerateEnvKeys(projectId uuid.UUID, email string, privateKey []byte, envName string, policies map[string]cryptutils.SecretPolicy, overwrite bool, wrappedKey *cryptutils.WrappedKey, message string) (map[string]string, error) {
 
-	return nil
+	generated := make(map[string]string)
+	metadata := Metadata{
+		Type:      "env_generated",
+		Message:   message,
+		Generated: make(map[string]string),
+	}
+
+	err := modifyLatestEnv(projectId, email, privateKey, envName, wrappedKey, &metadata, func(env map[string]string, info map[string]cryptutils.KeyInfo) error {
+		for key, policy := range policies {
+			if _, exists := env[key]; exists && !overwrite {
+				continue
+			}
+			value, err := cryptutils.GenerateSecret(policy)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if err := cryptutils.ValidateEnvEntry(key, value); err != nil {
+				return err
+			}
+			env[key] = value
+			generated[key] = value
+			// The expiry belonged to the old value.
+			ki := info[key]
+			ki.Expires = time.Time{}
+			info[key] = ki
+			metadata.Keys = append(metadata.Keys, key)
+			metadata.Generated[key] = policy.String()
+		}
+		if len(generated) == 0 {
+			return errNoChange
+		}
+		sort.Strings(metadata.Keys)
+		return nil
+	})
+	if err != nil {
+		clear(generated)
+		return nil, err
+	}
+
+	return generated, nil
+}
+
+// UpdateKeyInfo lets update change the info of key, which must be set, and
+// pushes the values unchanged with the new info.
+func UpdateKeyInfo(projectId uuid.UUID, email string, privateKey []byte, envName, key string, wrappedKey *cryptutils.WrappedKey, metadata Metadata, update func(info *cryptutils.KeyInfo)) error {
+
+	metadata.Keys = []string{key}
+
+	return modifyLatestEnv(projectId, email, privateKey, envName, wrappedKey, &metadata, func(env map[string]string, info map[string]cryptutils.KeyInfo) error {
+		if _, exists := env[key]; !exists {
+			return fmt.Errorf("key %s is not set", key)
+		}
+		ki := info[key]
+		update(&ki)
+		if err := ki.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		info[key] = ki
+		return nil
+	})
 }
-func RollbackEnv(projectId uuid.UUID, email string, privateKey []byte, version int32, wrappedKey *cryptutils.WrappedKey) error {
 
-	updationEnv, err := PullEnv(projectId, email, privateKey, version, wrappedKey)
+func GetEnvKey(projectId uuid.UUID, email string, privateKey []byte, envName, key string, wrappedKey *cryptutils.WrappedKey) (string, error) {
+
+	env, _, err := PullLatestEnv(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	value, exists := env[key]
+	if !exists {
+		return "", fmt.Errorf("key %s is not set", key)
 	}
 
-	err = PushRollbackEnv(projectId, email, privateKey, updationEnv, wrappedKey)
+	return value, nil
+}
+
+// ReplaceEnv pushes env as the version following baseVersion, as returned by
+// PullLatestEnv. It fails if somebody else pushed in the meantime. Key info
+// carries over as described for pushEnvVersion.
+func ReplaceEnv(projectId uuid.UUID, email string, privateKey []byte, envName string, env map[string]string, baseVersion int32, wrappedKey *cryptutils.WrappedKey, metadata Metadata) error {
+	for key, value := range env {
+		if err := cryptutils.ValidateEnvEntry(key, value); err != nil {
+			return err
+		}
+	}
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	latest := latestVersion(history)
+	if latest.versionOrZero() != baseVersion {
+		return fmt.Errorf("%s changed to version %d while editing version %d", envName, latest.versionOrZero(), baseVersion)
+	}
+
+	return pushEnvVersion(projectId, email, privateKey, envName, env, wrappedKey, metadata, latest)
 }
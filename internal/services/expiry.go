@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ParseExpiry reads when a value expires: a date, which expires at its
+// start in UTC, an RFC 3339 time, or a period from now such as "30d".
+func ParseExpiry(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	if d, err := ParseDuration(s); err == nil && d > 0 {
+		return now.Add(d).UTC().Truncate(time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q, use a date like 2025-12-31, an RFC 3339 time or a period like 30d", s)
+}
+
+// ExpiringKey is a key of a version with an expiry date.
+type ExpiringKey struct {
+	Key     string
+	Expires time.Time
+}
+
+func (k ExpiringKey) Expired(now time.Time) bool {
+	return !k.Expires.After(now)
+}
+
+// ExpiringKeys lists the keys of v that expire before now plus within,
+// including those that already expired, soonest first.
+func ExpiringKeys(v *EnvVersion, now time.Time, within time.Duration) []ExpiringKey {
+	var keys []ExpiringKey
+	for key, info := range v.keyInfoOrNil() {
+		if _, exists := v.Env[key]; !exists || info.Expires.IsZero() {
+			continue
+		}
+		if !info.Expires.After(now.Add(within)) {
+			keys = append(keys, ExpiringKey{Key: key, Expires: info.Expires})
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Expires.Equal(keys[j].Expires) {
+			return keys[i].Expires.Before(keys[j].Expires)
+		}
+		return keys[i].Key < keys[j].Key
+	})
+
+	return keys
+}
+
+// WithoutExpired returns env, values taken from v, leaving out those that
+// expired at now if omit is set, and the keys of env that expired either
+// way.
+func WithoutExpired(v *EnvVersion, env map[string]string, now time.Time, omit bool) (map[string]string, []ExpiringKey) {
+	var expired []ExpiringKey
+	for _, k := range ExpiringKeys(v, now, 0) {
+		if _, exists := env[k.Key]; exists {
+			expired = append(expired, k)
+		}
+	}
+
+	result := make(map[string]string, len(env))
+	for key, value := range env {
+		result[key] = value
+	}
+	if omit {
+		for _, k := range expired {
+			delete(result, k.Key)
+		}
+	}
+
+	return result, expired
+}
@@ -0,0 +1,610 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// FSBackend keeps everything in a local directory, typically inside the
+// repository of the project it protects:
+//
+//	users/<email>.json
+//	projects/<project id>/project.json
+//	projects/<project id>/keys/<user id>.json
+//	projects/<project id>/envs/<env name>/<version>.json
+//	projects/<project id>/service-accounts/<name>.json
+//
+// There is no server to check passwords, logging in only succeeds if the
+// password decrypts the stored private key.
+type FSBackend struct {
+	Root string
+
+	// Git commits every written file to the repository containing Root.
+	Git bool
+}
+
+type fsProject struct {
+	Id   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+type fsServiceAccount struct {
+	ServiceAccountInfo
+	SecretHash []byte                `json:"secret_hash"`
+	PublicKey  []byte                `json:"public_key"`
+	WrappedKey cryptutils.WrappedKey `json:"wrapped_key"`
+}
+
+func NewFSBackend(root string, git bool) (*FSBackend, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	if git {
+		if err := exec.Command("git", "-C", root, "rev-parse", "--is-inside-work-tree").Run(); err != nil {
+			return nil, fmt.Errorf("%s is not inside a git work tree", root)
+		}
+	}
+
+	return &FSBackend{Root: root, Git: git}, nil
+}
+
+func (b *FSBackend) Register(req CreateRequestBody) error {
+	path := b.userPath(req.Email)
+
+	user := UserBody{
+		Id:                      uuid.New(),
+		Email:                   req.Email,
+		PublicKey:               req.PublicKey,
+		EncryptedUserPrivateKey: req.EncryptedUserPrivateKey,
+		PrivateKeySalt:          req.PrivateKeySalt,
+		PrivateKeyNonce:         req.PrivateKeyNonce,
+		ArgonParams:             cryptutils.DefaultArgon2Params,
+		SigningPublicKey:        req.SigningPublicKey,
+		EncryptedSigningKey:     req.EncryptedSigningKey,
+		SigningKeySalt:          req.SigningKeySalt,
+		SigningKeyNonce:         req.SigningKeyNonce,
+		IdentityType:            req.IdentityType,
+	}
+
+	if err := b.writeNew(path, user); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("user %s already exists", req.Email)
+		}
+		return err
+	}
+
+	return b.commit("register "+req.Email, path)
+}
+
+func (b *FSBackend) Login(req LoginRequestBody) (*UserBody, error) {
+	var user UserBody
+	if err := readJSON(b.userPath(req.Email), &user); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("user %s not found", req.Email)
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (b *FSBackend) GetUserKeys(req GetUserKeysRequest) (*UserKeysResponse, error) {
+	user, err := b.Login(LoginRequestBody{Email: req.Email})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserKeysResponse{
+		Id:               user.Id,
+		Email:            user.Email,
+		PublicKey:        user.PublicKey,
+		SigningPublicKey: user.SigningPublicKey,
+	}, nil
+}
+
+func (b *FSBackend) CreateProject(req ProjectCreateRequest) error {
+	if err := checkName("project", req.Name); err != nil {
+		return err
+	}
+	if _, _, err := b.findProject(req.Name, req.UserId); err == nil {
+		return fmt.Errorf("project %s already exists", req.Name)
+	}
+
+	project := fsProject{Id: uuid.New(), Name: req.Name}
+	projectPath := filepath.Join(b.projectDir(project.Id), "project.json")
+	keyPath := b.keyPath(project.Id, req.UserId)
+
+	if err := b.writeNew(projectPath, project); err != nil {
+		return err
+	}
+
+	err := b.writeNew(keyPath, cryptutils.WrappedKey{
+		WrappedPMK:       req.WrappedPMK,
+		WrapNonce:        req.WrapNonce,
+		WrapEphemeralPub: req.EphemeralPublicKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.commit("create project "+req.Name, projectPath, keyPath)
+}
+
+func (b *FSBackend) GetProjectKeys(req GetUserProjectRequest) (*GetUserProjectResponse, error) {
+	project, wrappedKey, err := b.findProject(req.ProjectName, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetUserProjectResponse{
+		ProjectId:          project.Id,
+		WrappedPMK:         wrappedKey.WrappedPMK,
+		WrapNonce:          wrappedKey.WrapNonce,
+		EphemeralPublicKey: wrappedKey.WrapEphemeralPub,
+	}, nil
+}
+
+func (b *FSBackend) AddProjectMember(req AddProjectMemberRequest) error {
+	if err := b.authorize(req.ProjectId, req.Email); err != nil {
+		return err
+	}
+
+	path := b.keyPath(req.ProjectId, req.MemberId)
+	err := b.writeNew(path, cryptutils.WrappedKey{
+		WrappedPMK:       req.WrappedPMK,
+		WrapNonce:        req.WrapNonce,
+		WrapEphemeralPub: req.EphemeralPublicKey,
+	})
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("user %s is already a member", req.MemberId)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.commit(fmt.Sprintf("share project %s with %s", req.ProjectId, req.MemberId), path)
+}
+
+func (b *FSBackend) CreateEnv(req AddEnvRequest) error {
+	return b.appendVersion(req.ProjectId, req.Email, req.EnvName, EnvResponse{
+		CipherText: req.CipherText,
+		Nonce:      req.Nonce,
+		Metadata:   req.Metadata,
+	}, false)
+}
+
+func (b *FSBackend) UpdateEnv(req UpdateEnvRequest) error {
+	return b.appendVersion(req.ProjectId, req.Email, req.EnvName, EnvResponse{
+		CipherText: req.CipherText,
+		Nonce:      req.Nonce,
+		Metadata:   req.Metadata,
+	}, true)
+}
+
+func (b *FSBackend) GetEnv(req GetEnvRequest) (*GetEnvResponse, error) {
+	if err := checkName("env", req.EnvName); err != nil {
+		return nil, err
+	}
+	if err := b.authorizeRead(req.ProjectId, req.Email, req.EnvName); err != nil {
+		return nil, err
+	}
+
+	var version EnvResponse
+	if err := readJSON(b.versionPath(req.ProjectId, req.EnvName, req.Version), &version); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("version %d of %s not found", req.Version, req.EnvName)
+		}
+		return nil, err
+	}
+
+	return &GetEnvResponse{CipherText: version.CipherText, Nonce: version.Nonce}, nil
+}
+
+func (b *FSBackend) GetEnvVersions(req GetEnvVersionsRequest) ([]EnvResponse, error) {
+	if err := checkName("env", req.EnvName); err != nil {
+		return nil, err
+	}
+	if err := b.authorizeRead(req.ProjectId, req.Email, req.EnvName); err != nil {
+		return nil, err
+	}
+
+	return b.readVersions(req.ProjectId, req.EnvName)
+}
+
+func (b *FSBackend) CreateServiceAccount(req ServiceAccountRequest) error {
+	if err := checkName("service account", req.Name); err != nil {
+		return err
+	}
+	if err := b.authorize(req.ProjectId, req.Email); err != nil {
+		return err
+	}
+
+	secretHash := sha256.Sum256(req.Secret)
+	path := b.serviceAccountPath(req.ProjectId, req.Name)
+	err := b.writeNew(path, fsServiceAccount{
+		ServiceAccountInfo: ServiceAccountInfo{
+			Id:        req.Id,
+			Name:      req.Name,
+			Envs:      req.Envs,
+			CreatedBy: req.Email,
+			CreatedAt: time.Now().UTC(),
+		},
+		SecretHash: secretHash[:],
+		PublicKey:  req.PublicKey,
+		WrappedKey: cryptutils.WrappedKey{
+			WrappedPMK:       req.WrappedPMK,
+			WrapNonce:        req.WrapNonce,
+			WrapEphemeralPub: req.EphemeralPublicKey,
+		},
+	})
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("service account %s already exists", req.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.commit("create service account "+req.Name, path)
+}
+
+func (b *FSBackend) RotateServiceAccount(req ServiceAccountRequest) error {
+	return b.updateServiceAccount(req.ProjectId, req.Email, req.Name, "rotate", func(account *fsServiceAccount) {
+		secretHash := sha256.Sum256(req.Secret)
+		account.SecretHash = secretHash[:]
+		account.PublicKey = req.PublicKey
+		account.WrappedKey = cryptutils.WrappedKey{
+			WrappedPMK:       req.WrappedPMK,
+			WrapNonce:        req.WrapNonce,
+			WrapEphemeralPub: req.EphemeralPublicKey,
+		}
+		account.RotatedAt = time.Now().UTC()
+	})
+}
+
+func (b *FSBackend) RevokeServiceAccount(req RevokeServiceAccountRequest) error {
+	return b.updateServiceAccount(req.ProjectId, req.Email, req.Name, "revoke", func(account *fsServiceAccount) {
+		// Drop the key material, a revoked account never logs in again.
+		account.SecretHash = nil
+		account.WrappedKey = cryptutils.WrappedKey{}
+		account.RevokedAt = time.Now().UTC()
+	})
+}
+
+func (b *FSBackend) ListServiceAccounts(req ListServiceAccountsRequest) ([]ServiceAccountInfo, error) {
+	if err := b.authorize(req.ProjectId, req.Email); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(b.projectDir(req.ProjectId), "service-accounts", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []ServiceAccountInfo{}
+	for _, path := range paths {
+		var account fsServiceAccount
+		if err := readJSON(path, &account); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account.ServiceAccountInfo)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+
+	return accounts, nil
+}
+
+func (b *FSBackend) ServiceLogin(req ServiceLoginRequest) (*ServiceLoginResponse, error) {
+	account, projectId, err := b.findServiceAccount(req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	secretHash := sha256.Sum256(req.Secret)
+	if account.Revoked() || subtle.ConstantTimeCompare(secretHash[:], account.SecretHash) != 1 {
+		return nil, errors.New("invalid or revoked service account token")
+	}
+
+	var project fsProject
+	if err := readJSON(filepath.Join(b.projectDir(projectId), "project.json"), &project); err != nil {
+		return nil, err
+	}
+
+	return &ServiceLoginResponse{
+		Id:                 account.Id,
+		Name:               account.Name,
+		ProjectId:          project.Id,
+		ProjectName:        project.Name,
+		Envs:               account.Envs,
+		PublicKey:          account.PublicKey,
+		WrappedPMK:         account.WrappedKey.WrappedPMK,
+		WrapNonce:          account.WrappedKey.WrapNonce,
+		EphemeralPublicKey: account.WrappedKey.WrapEphemeralPub,
+	}, nil
+}
+
+func (b *FSBackend) updateServiceAccount(projectId uuid.UUID, email, name, action string, update func(account *fsServiceAccount)) error {
+	if err := checkName("service account", name); err != nil {
+		return err
+	}
+	if err := b.authorize(projectId, email); err != nil {
+		return err
+	}
+
+	path := b.serviceAccountPath(projectId, name)
+	var account fsServiceAccount
+	if err := readJSON(path, &account); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("service account %s not found", name)
+		}
+		return err
+	}
+	if account.Revoked() {
+		return fmt.Errorf("service account %s is revoked", name)
+	}
+
+	update(&account)
+
+	if err := b.writeFile(path, account); err != nil {
+		return err
+	}
+
+	return b.commit(action+" service account "+name, path)
+}
+
+func (b *FSBackend) findServiceAccount(id uuid.UUID) (*fsServiceAccount, uuid.UUID, error) {
+	paths, err := filepath.Glob(filepath.Join(b.Root, "projects", "*", "service-accounts", "*.json"))
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	for _, path := range paths {
+		var account fsServiceAccount
+		if err := readJSON(path, &account); err != nil || account.Id != id {
+			continue
+		}
+
+		projectId, err := uuid.Parse(filepath.Base(filepath.Dir(filepath.Dir(path))))
+		if err != nil {
+			return nil, uuid.Nil, err
+		}
+		return &account, projectId, nil
+	}
+
+	return nil, uuid.Nil, errors.New("invalid or revoked service account token")
+}
+
+func (b *FSBackend) appendVersion(projectId uuid.UUID, email, envName string, version EnvResponse, mustExist bool) error {
+	if err := checkName("env", envName); err != nil {
+		return err
+	}
+	if err := b.authorize(projectId, email); err != nil {
+		return err
+	}
+
+	versions, err := b.readVersions(projectId, envName)
+	if err != nil {
+		return err
+	}
+	if mustExist && len(versions) == 0 {
+		return fmt.Errorf("env %s not found", envName)
+	}
+
+	version.Version = int32(len(versions)) + 1
+	path := b.versionPath(projectId, envName, version.Version)
+
+	// writeNew fails if another client pushed the same version number first.
+	if err := b.writeNew(path, version); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("version %d of %s was pushed concurrently, retry", version.Version, envName)
+		}
+		return err
+	}
+
+	return b.commit(fmt.Sprintf("%s %s version %d", version.Metadata.Type, envName, version.Version), path)
+}
+
+func (b *FSBackend) readVersions(projectId uuid.UUID, envName string) ([]EnvResponse, error) {
+	dir := filepath.Join(b.projectDir(projectId), "envs", url.PathEscape(envName))
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]EnvResponse, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		var version EnvResponse
+		if err := readJSON(filepath.Join(dir, entry.Name()), &version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+
+	return versions, nil
+}
+
+func (b *FSBackend) authorize(projectId uuid.UUID, email string) error {
+	var user UserBody
+	if err := readJSON(b.userPath(email), &user); err != nil {
+		return fmt.Errorf("user %s not found", email)
+	}
+
+	if _, err := os.Stat(b.keyPath(projectId, user.Id)); err != nil {
+		return fmt.Errorf("user %s has no access to project %s", email, projectId)
+	}
+
+	return nil
+}
+
+// authorizeRead is authorize, extended to service accounts allowed to read
+// envName.
+func (b *FSBackend) authorizeRead(projectId uuid.UUID, email, envName string) error {
+	id, isService := ParseServicePrincipal(email)
+	if !isService {
+		return b.authorize(projectId, email)
+	}
+
+	account, accountProjectId, err := b.findServiceAccount(id)
+	if err != nil {
+		return err
+	}
+	if account.Revoked() || accountProjectId != projectId || !slices.Contains(account.Envs, envName) {
+		return fmt.Errorf("service account %s has no access to %s", account.Name, envName)
+	}
+
+	return nil
+}
+
+func (b *FSBackend) findProject(name string, userId uuid.UUID) (*fsProject, *cryptutils.WrappedKey, error) {
+	entries, err := os.ReadDir(filepath.Join(b.Root, "projects"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		var project fsProject
+		if err := readJSON(filepath.Join(b.Root, "projects", entry.Name(), "project.json"), &project); err != nil {
+			continue
+		}
+		if project.Name != name {
+			continue
+		}
+
+		var wrappedKey cryptutils.WrappedKey
+		if err := readJSON(b.keyPath(project.Id, userId), &wrappedKey); err != nil {
+			continue
+		}
+
+		return &project, &wrappedKey, nil
+	}
+
+	return nil, nil, fmt.Errorf("project %s not found", name)
+}
+
+// checkName rejects names that url.PathEscape leaves alone but that mean
+// something else as a path element, so an env named ".." cannot reach
+// outside its directory.
+func checkName(kind, name string) error {
+	switch name {
+	case "", ".", "..":
+		return fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return nil
+}
+
+func (b *FSBackend) userPath(email string) string {
+	return filepath.Join(b.Root, "users", url.PathEscape(email)+".json")
+}
+
+func (b *FSBackend) projectDir(projectId uuid.UUID) string {
+	return filepath.Join(b.Root, "projects", projectId.String())
+}
+
+func (b *FSBackend) keyPath(projectId, userId uuid.UUID) string {
+	return filepath.Join(b.projectDir(projectId), "keys", userId.String()+".json")
+}
+
+func (b *FSBackend) serviceAccountPath(projectId uuid.UUID, name string) string {
+	return filepath.Join(b.projectDir(projectId), "service-accounts", url.PathEscape(name)+".json")
+}
+
+func (b *FSBackend) versionPath(projectId uuid.UUID, envName string, version int32) string {
+	return filepath.Join(b.projectDir(projectId), "envs", url.PathEscape(envName), fmt.Sprintf("%06d.json", version))
+}
+
+// writeNew writes v as JSON to path and fails with os.ErrExist if the file
+// is already there.
+func (b *FSBackend) writeNew(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeFile replaces path with v as JSON.
+func (b *FSBackend) writeFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (b *FSBackend) commit(message string, paths ...string) error {
+	if !b.Git {
+		return nil
+	}
+
+	add := exec.Command("git", append([]string{"-C", b.Root, "add", "--"}, paths...)...)
+	if out, err := add.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add: %s", strings.TrimSpace(string(out)))
+	}
+
+	commit := exec.Command("git", append([]string{"-C", b.Root, "commit", "-q", "-m", "envcrypt: " + message, "--"}, paths...)...)
+	if out, err := commit.CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func readJSON(path string, v any) error {
+	data, err := readLimited(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
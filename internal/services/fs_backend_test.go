@@ -0,0 +1,42 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFSBackendRejectsPathNames(t *testing.T) {
+	b, err := NewFSBackend(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectId := uuid.New()
+
+	for _, name := range []string{"", ".", ".."} {
+		if err := b.CreateEnv(AddEnvRequest{ProjectId: projectId, EnvName: name}); err == nil {
+			t.Errorf("created env %q", name)
+		}
+		if _, err := b.GetEnvVersions(GetEnvVersionsRequest{ProjectId: projectId, EnvName: name}); err == nil {
+			t.Errorf("read versions of env %q", name)
+		}
+		if _, err := b.GetEnv(GetEnvRequest{ProjectId: projectId, EnvName: name, Version: 1}); err == nil {
+			t.Errorf("read env %q", name)
+		}
+		if err := b.CreateServiceAccount(ServiceAccountRequest{ProjectId: projectId, Name: name}); err == nil {
+			t.Errorf("created service account %q", name)
+		}
+		if err := b.CreateProject(ProjectCreateRequest{Name: name}); err == nil {
+			t.Errorf("created project %q", name)
+		}
+	}
+
+	entries, err := os.ReadDir(b.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("wrote %d entries to the store", len(entries))
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// EnvVersion is a single decrypted version of an environment.
+type EnvVersion struct {
+	Version  int32
+	Metadata Metadata
+	Env      map[string]string
+	KeyInfo  map[string]cryptutils.KeyInfo
+
+	// Hash is the cryptutils.VersionHash the next version commits to.
+	Hash []byte
+
+	// PushedAt is when the author pushed the version, taken from the
+	// encrypted payload. It is zero for versions pushed by older clients,
+	// which only have the Metadata.Timestamp the server could change.
+	PushedAt time.Time
+
+	// Signed is set when the version carries a valid signature of
+	// Metadata.Author.
+	Signed bool
+}
+
+func latestVersion(history []EnvVersion) *EnvVersion {
+	if len(history) == 0 {
+		return nil
+	}
+	return &history[len(history)-1]
+}
+
+func (v *EnvVersion) envOrNil() map[string]string {
+	if v == nil {
+		return nil
+	}
+	return v.Env
+}
+
+func (v *EnvVersion) keyInfoOrNil() map[string]cryptutils.KeyInfo {
+	if v == nil {
+		return nil
+	}
+	return v.KeyInfo
+}
+
+func (v *EnvVersion) versionOrZero() int32 {
+	if v == nil {
+		return 0
+	}
+	return v.Version
+}
+
+// PullEnvHistory decrypts every version of an environment, oldest first, and
+// verifies the hash chain linking them. If the chain is broken the history is
+// returned together with a *ChainError describing every problem.
+func PullEnvHistory(projectId uuid.UUID, email string, privateKey []byte, envName string, wrappedKey *cryptutils.WrappedKey) ([]EnvVersion, error) {
+
+	envVersions, err := fetchEnvVersions(projectId, email, envName)
+	if err != nil {
+		return nil, err
+	}
+	if len(envVersions) > MaxEnvVersions {
+		return nil, fmt.Errorf("%s has %d versions: %w of %d", envName, len(envVersions), ErrTooManyVersions, MaxEnvVersions)
+	}
+
+	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer pmk.Destroy()
+
+	sort.Slice(envVersions, func(i, j int) bool { return envVersions[i].Version < envVersions[j].Version })
+
+	history := make([]EnvVersion, 0, len(envVersions))
+	payloads := make([]*cryptutils.EnvPayload, 0, len(envVersions))
+	for _, envVersion := range envVersions {
+		payload, env, err := decryptEnvResponse(pmk.Bytes(), &envVersion)
+		if err != nil {
+			return nil, fmt.Errorf("version %d: %w", envVersion.Version, err)
+		}
+
+		// Versions pushed by older clients carry the changed keys in the
+		// plaintext metadata instead.
+		metadata := envVersion.Metadata
+		if payload.Keys != nil || payload.Generated != nil {
+			metadata.Keys, metadata.Generated = payload.Keys, payload.Generated
+		}
+		if !payload.Timestamp.IsZero() {
+			metadata.Timestamp = payload.Timestamp
+		}
+
+		history = append(history, EnvVersion{
+			Version:  envVersion.Version,
+			Metadata: metadata,
+			Env:      env,
+			KeyInfo:  payload.KeyInfo,
+			Hash:     cryptutils.VersionHash(envVersion.CipherText, envVersion.Nonce),
+			PushedAt: payload.Timestamp,
+		})
+		payloads = append(payloads, payload)
+	}
+
+	if err := verifyChain(projectId, envName, history, payloads); err != nil {
+		return history, err
+	}
+
+	return history, nil
+}
+
+func decryptEnvResponse(pmk []byte, envVersion *EnvResponse) (*cryptutils.EnvPayload, map[string]string, error) {
+	decryptedData, err := cryptutils.DecryptENV(pmk, envVersion.CipherText, envVersion.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer decryptedData.Destroy()
+
+	payload, err := cryptutils.DecodeEnvPayload(decryptedData.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	// Env points into decryptedData.
+	payload.Env = nil
+
+	env, err := cryptutils.ReadEnvFromStorage(decryptedData.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return payload, env, nil
+}
+
+// VersionChanges computes, client side, what every version changed compared
+// to the one before it. The result is indexed like history.
+func VersionChanges(history []EnvVersion) []cryptutils.DiffingResult {
+	changes := make([]cryptutils.DiffingResult, len(history))
+
+	var previous map[string]string
+	for i, version := range history {
+		changes[i] = cryptutils.DiffEnvVersions(previous, version.Env)
+		sortDiff(&changes[i])
+		previous = version.Env
+	}
+
+	return changes
+}
+
+// KeyChange is one change to the value of a single key.
+type KeyChange struct {
+	Version  int32
+	Metadata Metadata
+	Value    string
+	Removed  bool
+}
+
+// KeyHistory lists every version in which key was added, modified or
+// removed, oldest first.
+func KeyHistory(history []EnvVersion, key string) []KeyChange {
+	var changes []KeyChange
+
+	previous, existed := "", false
+	for _, version := range history {
+		value, exists := version.Env[key]
+
+		switch {
+		case exists && (!existed || value != previous):
+			changes = append(changes, KeyChange{Version: version.Version, Metadata: version.Metadata, Value: value})
+		case !exists && existed:
+			changes = append(changes, KeyChange{Version: version.Version, Metadata: version.Metadata, Removed: true})
+		}
+
+		previous, existed = value, exists
+	}
+
+	return changes
+}
+
+// BlameEntry attributes a key of the latest version to the version that last
+// changed its value.
+type BlameEntry struct {
+	Key      string
+	Version  int32
+	Metadata Metadata
+}
+
+// Blame attributes every key of the latest version, sorted by key.
+func Blame(history []EnvVersion) []BlameEntry {
+	if len(history) == 0 {
+		return nil
+	}
+
+	latest := history[len(history)-1].Env
+	entries := make([]BlameEntry, 0, len(latest))
+	for key := range latest {
+		changes := KeyHistory(history, key)
+		last := changes[len(changes)-1]
+
+		entries = append(entries, BlameEntry{
+			Key:      key,
+			Version:  last.Version,
+			Metadata: last.Metadata,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries
+}
+
+func sortDiff(diff *cryptutils.DiffingResult) {
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Modified)
+	sort.Strings(diff.Removed)
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPBackend talks to an envcrypt server.
+type HTTPBackend struct {
+	BaseURL string
+}
+
+func (b *HTTPBackend) Register(req CreateRequestBody) error {
+	return b.post("/users/create", req, http.StatusCreated, nil)
+}
+
+func (b *HTTPBackend) Login(req LoginRequestBody) (*UserBody, error) {
+	var resp LoginResponseBody
+	if err := b.post("/users/login", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.User, nil
+}
+
+func (b *HTTPBackend) GetUserKeys(req GetUserKeysRequest) (*UserKeysResponse, error) {
+	var resp UserKeysResponse
+	if err := b.post("/users/keys", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (b *HTTPBackend) CreateProject(req ProjectCreateRequest) error {
+	return b.post("/projects/create", req, http.StatusCreated, nil)
+}
+
+func (b *HTTPBackend) GetProjectKeys(req GetUserProjectRequest) (*GetUserProjectResponse, error) {
+	var resp GetUserProjectResponse
+	if err := b.post("/projects/keys", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (b *HTTPBackend) AddProjectMember(req AddProjectMemberRequest) error {
+	return b.post("/projects/members/add", req, http.StatusCreated, nil)
+}
+
+func (b *HTTPBackend) CreateServiceAccount(req ServiceAccountRequest) error {
+	return b.post("/service-accounts/create", req, http.StatusCreated, nil)
+}
+
+func (b *HTTPBackend) RotateServiceAccount(req ServiceAccountRequest) error {
+	return b.post("/service-accounts/rotate", req, http.StatusOK, nil)
+}
+
+func (b *HTTPBackend) RevokeServiceAccount(req RevokeServiceAccountRequest) error {
+	return b.post("/service-accounts/revoke", req, http.StatusOK, nil)
+}
+
+func (b *HTTPBackend) ListServiceAccounts(req ListServiceAccountsRequest) ([]ServiceAccountInfo, error) {
+	var resp []ServiceAccountInfo
+	if err := b.post("/service-accounts/list", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (b *HTTPBackend) ServiceLogin(req ServiceLoginRequest) (*ServiceLoginResponse, error) {
+	var resp ServiceLoginResponse
+	if err := b.post("/service-accounts/login", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (b *HTTPBackend) CreateEnv(req AddEnvRequest) error {
+	return b.post("/env/create", req, http.StatusCreated, nil)
+}
+
+func (b *HTTPBackend) UpdateEnv(req UpdateEnvRequest) error {
+	return b.post("/env/update", req, http.StatusOK, nil)
+}
+
+func (b *HTTPBackend) GetEnv(req GetEnvRequest) (*GetEnvResponse, error) {
+	var resp GetEnvResponse
+	if err := b.post("/env/search", req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (b *HTTPBackend) GetEnvVersions(req GetEnvVersionsRequest) ([]EnvResponse, error) {
+	var resp GetEnvVersionsResponse
+	err := b.post("/env/search/all", req, http.StatusOK, &resp)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.EnvVersions, nil
+}
+
+// maxErrorBody is how much of an error response ends up in StatusError.
+const maxErrorBody = 4 << 10
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.Path, e.StatusCode, e.Body)
+}
+
+func (b *HTTPBackend) post(path string, request any, expectedStatus int, response any) error {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(b.BaseURL+path, "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body := limitBody(resp.Body)
+
+	if resp.StatusCode != expectedStatus {
+		body, _ := io.ReadAll(io.LimitReader(body, maxErrorBody))
+		return &StatusError{Path: path, StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return json.NewDecoder(body).Decode(response)
+}
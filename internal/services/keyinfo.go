@@ -0,0 +1,26 @@
+package services
+
+import (
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+// WithTags returns the entries of env whose key info in v carries at least
+// one of tags.
+func WithTags(v *EnvVersion, env map[string]string, tags []string) map[string]string {
+	tagged := make(map[string]string)
+	for key, value := range env {
+		if hasAnyTag(v.keyInfoOrNil()[key], tags) {
+			tagged[key] = value
+		}
+	}
+	return tagged
+}
+
+func hasAnyTag(info cryptutils.KeyInfo, tags []string) bool {
+	for _, tag := range tags {
+		if info.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+var (
+	// MaxResponseSize bounds every response body read from a server and
+	// every file read by FSBackend and the local cache.
+	MaxResponseSize int64 = 256 << 20 // 256 MB
+
+	// MaxEnvVersions bounds the number of versions of a single env that
+	// are decrypted.
+	MaxEnvVersions = 10000
+)
+
+var (
+	ErrResponseTooLarge = errors.New("response exceeds the size limit")
+	ErrTooManyVersions  = errors.New("env exceeds the version count limit")
+)
+
+// Limits are the limits on what reading from a server may allocate, see
+// cryptutils.Limits and MaxResponseSize.
+type Limits struct {
+	cryptutils.Limits
+	MaxResponseSize int64
+}
+
+// CurrentLimits returns the limits in effect.
+func CurrentLimits() Limits {
+	return Limits{Limits: cryptutils.EnvLimits, MaxResponseSize: MaxResponseSize}
+}
+
+// SetLimits puts limits into effect.
+func SetLimits(limits Limits) {
+	cryptutils.EnvLimits = limits.Limits
+	MaxResponseSize = limits.MaxResponseSize
+}
+
+// ParseLimits overrides limits with a comma separated list such as
+// "env=8MB,keys=20000,key=512,value=1MB,response=512MB", which is how
+// ENVCRYPT_LIMITS is given. Sizes take an optional KB, MB or GB suffix.
+func ParseLimits(spec string, limits Limits) (Limits, error) {
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(field, "=")
+
+		var n int64
+		var err error
+		if name == "keys" {
+			n, err = strconv.ParseInt(value, 10, 64)
+		} else {
+			n, err = parseSize(value)
+		}
+		if err != nil || n <= 0 || n > 1<<40 {
+			return limits, fmt.Errorf("invalid limit %q", field)
+		}
+
+		switch name {
+		case "env":
+			limits.MaxEnvSize = int(n)
+		case "keys":
+			limits.MaxKeys = int(n)
+		case "key":
+			limits.MaxKeyLength = int(n)
+		case "value":
+			limits.MaxValueLength = int(n)
+		case "response":
+			limits.MaxResponseSize = n
+		default:
+			return limits, fmt.Errorf("unknown limit %q, use env, keys, key, value or response", name)
+		}
+	}
+
+	return limits, nil
+}
+
+func parseSize(s string) (int64, error) {
+	upper := strings.ToUpper(s)
+	for _, unit := range []struct {
+		suffix string
+		shift  uint
+	}{{"GB", 30}, {"MB", 20}, {"KB", 10}, {"B", 0}} {
+		if number, found := strings.CutSuffix(upper, unit.suffix); found {
+			n, err := strconv.ParseInt(number, 10, 64)
+			if err != nil || n > 1<<40>>unit.shift {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return n << unit.shift, nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// limitBody fails reads past MaxResponseSize with ErrResponseTooLarge.
+func limitBody(body io.ReadCloser) io.ReadCloser {
+	return &responseLimiter{http.MaxBytesReader(nil, body, MaxResponseSize)}
+}
+
+type responseLimiter struct {
+	io.ReadCloser
+}
+
+func (r *responseLimiter) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, MaxResponseSize)
+	}
+	return n, err
+}
+
+// readLimited reads a local file of at most MaxResponseSize bytes.
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxResponseSize {
+		return nil, fmt.Errorf("%s: %w of %d bytes", path, ErrResponseTooLarge, MaxResponseSize)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,25 @@
+package services
+
+import "testing"
+
+func TestParseLimits(t *testing.T) {
+	base := CurrentLimits()
+
+	limits, err := ParseLimits("env=8MB, keys=20000,key=512,value=1kb,response=1GB", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.MaxEnvSize != 8<<20 || limits.MaxKeys != 20000 || limits.MaxKeyLength != 512 || limits.MaxValueLength != 1<<10 || limits.MaxResponseSize != 1<<30 {
+		t.Errorf("got %+v", limits)
+	}
+
+	if limits, err := ParseLimits("", base); err != nil || limits != base {
+		t.Errorf("empty spec: got %+v, %v", limits, err)
+	}
+
+	for _, spec := range []string{"env", "env=", "env=0", "env=-1", "keys=1MB", "env=1TB", "env=99999999GB", "size=1"} {
+		if _, err := ParseLimits(spec, base); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+// ClientVersion is recorded in the metadata of every pushed version. Release
+// builds override it with -ldflags "-X ...services.ClientVersion=v1.2.3".
+var ClientVersion = "dev"
+
+// Metadata is stored in plaintext next to every version. Keys and
+// Generated are sealed into the encrypted payload on push and filled in
+// again on pull, only versions pushed by older clients store them here.
+type Metadata struct {
+	Type string   `json:"type"`
+	Keys []string `json:"keys,omitempty"`
+
+	Author        string    `json:"author,omitempty"`
+	Timestamp     time.Time `json:"timestamp,omitzero"`
+	Host          string    `json:"host,omitempty"`
+	ClientVersion string    `json:"client_version,omitempty"`
+	Message       string    `json:"message,omitempty"`
+
+	// SourceVersion is the version restored by an env_rollback.
+	SourceVersion int32 `json:"source_version,omitempty"`
+
+	// Generated maps the keys of an env_generated version to the policy
+	// their values were generated with, e.g. "password:32".
+	Generated map[string]string `json:"generated,omitempty"`
+
+	// Signature is the author's Ed25519 signature over the stored version,
+	// see cryptutils.SignVersion.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// stamp fills in who pushed the version, when and from where.
+func (m *Metadata) stamp(email string) {
+	m.Author = email
+	m.Timestamp = time.Now().UTC()
+	m.ClientVersion = ClientVersion
+	if host, err := os.Hostname(); err == nil {
+		m.Host = host
+	}
+}
+
+// DiffKeys returns the sorted names of every key that differs between two
+// versions.
+func DiffKeys(oldVersion, newVersion map[string]string) []string {
+	diff := cryptutils.DiffEnvVersions(oldVersion, newVersion)
+
+	keys := make([]string, 0, len(diff.Added)+len(diff.Modified)+len(diff.Removed))
+	keys = append(keys, diff.Added...)
+	keys = append(keys, diff.Modified...)
+	keys = append(keys, diff.Removed...)
+	sort.Strings(keys)
+
+	return keys
+}
+
+// WriteVersionLog prints a single version in a git-log like layout. When
+// changes is set it is shown instead of the key names claimed by the
+// metadata.
+func WriteVersionLog(w io.Writer, v EnvVersion, changes *cryptutils.DiffingResult) {
+	m := v.Metadata
+
+	fmt.Fprintf(w, "version %d (%s)\n", v.Version, m.Type)
+	if m.Author != "" {
+		signed := "unsigned"
+		if v.Signed {
+			signed = "signature verified"
+		}
+		fmt.Fprintf(w, "Author:  %s (%s)\n", m.Author, signed)
+	}
+	if !m.Timestamp.IsZero() {
+		fmt.Fprintf(w, "Date:    %s\n", m.Timestamp.Local().Format(time.RFC1123))
+	}
+	if m.Host != "" || m.ClientVersion != "" {
+		fmt.Fprintf(w, "Client:  %s on %s\n", m.ClientVersion, m.Host)
+	}
+	if m.SourceVersion != 0 {
+		fmt.Fprintf(w, "Restore: version %d\n", m.SourceVersion)
+	}
+	if len(m.Generated) > 0 {
+		keys := make([]string, 0, len(m.Generated))
+		for key, policy := range m.Generated {
+			keys = append(keys, fmt.Sprintf("%s (%s)", key, policy))
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "Generated: %s\n", strings.Join(keys, ", "))
+	}
+	if changes != nil {
+		fmt.Fprintf(w, "Changes: %s\n", summarizeDiff(*changes))
+	} else if len(m.Keys) > 0 {
+		fmt.Fprintf(w, "Keys:    %s\n", strings.Join(m.Keys, ", "))
+	}
+	if m.Message != "" {
+		fmt.Fprintf(w, "\n    %s\n", strings.ReplaceAll(m.Message, "\n", "\n    "))
+	}
+	fmt.Fprintln(w)
+}
+
+func summarizeDiff(diff cryptutils.DiffingResult) string {
+	var parts []string
+	for _, key := range diff.Added {
+		parts = append(parts, "+"+key)
+	}
+	for _, key := range diff.Modified {
+		parts = append(parts, "~"+key)
+	}
+	for _, key := range diff.Removed {
+		parts = append(parts, "-"+key)
+	}
+	if len(parts) == 0 {
+		return "(none)"
+	}
+
+	return strings.Join(parts, " ")
+}
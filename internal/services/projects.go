@@ -1,11 +1,9 @@
 package services
 
 import (
-	"bytes"
 	"crypto/rand"
-	"encoding/json"
-	"log"
-	"net/http"
+	"errors"
+	"fmt"
 
 	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
 	"github.com/google/uuid"
@@ -21,13 +19,14 @@ type ProjectCreateRequest struct {
 
 func CreateProject(name string, userId uuid.UUID, publicKey []byte) error {
 
-	pmk := make([]byte, 32)
-	_, err := rand.Read(pmk)
-	if err != nil {
+	pmk := cryptutils.NewSecureBuffer(32)
+	defer pmk.Destroy()
+	if _, err := rand.Read(pmk.Bytes()); err != nil {
 		return err
 	}
 
-	wrappedKey, err := cryptutils.WrapPMKForUser(pmk, publicKey)
+	// The creator's own key, checked against the private key at login.
+	wrappedKey, err := cryptutils.WrapPMKForUser(pmk.Bytes(), publicKey, true)
 	if err != nil {
 		return err
 	}
@@ -40,16 +39,9 @@ func CreateProject(name string, userId uuid.UUID, publicKey []byte) error {
 		EphemeralPublicKey: wrappedKey.WrapEphemeralPub,
 	}
 
-	requestBody, err := json.Marshal(projectRequest)
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.Post("http://localhost:8080/projects/create", "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
+	if err := DefaultBackend.CreateProject(projectRequest); err != nil {
+		return fmt.Errorf("project creation failed: %w", err)
 	}
-	defer resp.Body.Close()
 
 	return nil
 }
@@ -70,27 +62,63 @@ func GetProject(projectName string, userId uuid.UUID) (*cryptutils.WrappedKey, *
 		ProjectName: projectName,
 		UserId:      userId,
 	}
-	requestBodyBytes, err := json.Marshal(requestBody)
+	responseBody, err := DefaultBackend.GetProjectKeys(requestBody)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("project lookup failed: %w", err)
 	}
 
-	resp, err := http.Post("http://localhost:8080/projects/keys", "application/json", bytes.NewBuffer(requestBodyBytes))
+	return &cryptutils.WrappedKey{
+		WrappedPMK:       responseBody.WrappedPMK,
+		WrapNonce:        responseBody.WrapNonce,
+		WrapEphemeralPub: responseBody.EphemeralPublicKey,
+	}, &responseBody.ProjectId, nil
+}
+
+type AddProjectMemberRequest struct {
+	ProjectId uuid.UUID `json:"project_id"`
+	Email     string    `json:"user_email"`
+
+	MemberId           uuid.UUID `json:"member_id"`
+	WrappedPMK         []byte    `json:"wrapped_pmk"`
+	WrapNonce          []byte    `json:"wrap_nonce"`
+	EphemeralPublicKey []byte    `json:"ephemeral_public_key"`
+}
+
+// ShareProject gives memberEmail access to a project by wrapping the PMK to
+// their public key. The key must have been verified with VerifyUserKey
+// unless force is set.
+func ShareProject(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, memberEmail string, force bool) error {
+
+	member, err := LookupUserKey(memberEmail)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	var responseBody GetUserProjectResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
+	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
 	if err != nil {
-		log.Println("ERROR in JSON decoding")
-		return nil, nil, err
+		return err
 	}
+	defer pmk.Destroy()
 
-	return &cryptutils.WrappedKey{
-		WrappedPMK:       responseBody.WrappedPMK,
-		WrapNonce:        responseBody.WrapNonce,
-		WrapEphemeralPub: responseBody.EphemeralPublicKey,
-	}, &responseBody.ProjectId, nil
+	memberKey, err := cryptutils.WrapPMKForUser(pmk.Bytes(), member.PublicKey, member.Verified || force)
+	if errors.Is(err, cryptutils.ErrUnverifiedRecipient) {
+		return fmt.Errorf("key of %s (%s) is not verified, compare fingerprints and run `trust verify %s` or pass force", memberEmail, member.Fingerprint(), memberEmail)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = DefaultBackend.AddProjectMember(AddProjectMemberRequest{
+		ProjectId:          projectId,
+		Email:              email,
+		MemberId:           member.Id,
+		WrappedPMK:         memberKey.WrappedPMK,
+		WrapNonce:          memberKey.WrapNonce,
+		EphemeralPublicKey: memberKey.WrapEphemeralPub,
+	})
+	if err != nil {
+		return fmt.Errorf("sharing project failed: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+var ErrNothingToRollback = errors.New("target version matches the current content, use force to push anyway")
+
+type RollbackOptions struct {
+	// Keys restricts the rollback to these keys, every other key keeps its
+	// value from the latest version. Empty means the whole environment.
+	Keys []string
+
+	// Force pushes the rollback even when it would not change anything.
+	Force bool
+
+	Message string
+}
+
+// RollbackPlan describes the version a rollback would push without pushing
+// it.
+type RollbackPlan struct {
+	SourceVersion int32
+	LatestVersion int32
+	Result        map[string]string
+	// KeyInfo is the key info of the latest version, with the expiry of
+	// every restored value taken from the source version as it belongs to
+	// the value.
+	KeyInfo map[string]cryptutils.KeyInfo
+	Changes cryptutils.DiffingResult
+}
+
+func (p *RollbackPlan) Empty() bool {
+	return len(p.Changes.Added)+len(p.Changes.Modified)+len(p.Changes.Removed) == 0
+}
+
+// PlanRollback computes the environment that restoring version would
+// produce on top of the latest version.
+func PlanRollback(projectId uuid.UUID, email string, privateKey []byte, envName string, version int32, wrappedKey *cryptutils.WrappedKey, keys []string) (*RollbackPlan, error) {
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceEnv map[string]string
+	var sourceInfo map[string]cryptutils.KeyInfo
+	for _, envVersion := range history {
+		if envVersion.Version == version {
+			sourceEnv, sourceInfo = envVersion.Env, envVersion.KeyInfo
+		}
+	}
+	if sourceEnv == nil {
+		return nil, fmt.Errorf("version %d of %s does not exist", version, envName)
+	}
+
+	latest := latestVersion(history)
+	latestEnv := latest.Env
+
+	result := sourceEnv
+	var restored []string
+	for key := range sourceEnv {
+		restored = append(restored, key)
+	}
+	if len(keys) > 0 {
+		result = make(map[string]string, len(latestEnv))
+		for key, value := range latestEnv {
+			result[key] = value
+		}
+
+		restored = nil
+		for _, key := range keys {
+			value, inSource := sourceEnv[key]
+			_, inLatest := latestEnv[key]
+
+			switch {
+			case inSource:
+				result[key] = value
+				restored = append(restored, key)
+			case inLatest:
+				delete(result, key)
+			default:
+				return nil, fmt.Errorf("key %s is in neither version %d nor the latest version", key, version)
+			}
+		}
+	}
+
+	// What a key is for is described in the latest version, only the
+	// expiry goes back with the value.
+	resultInfo := make(map[string]cryptutils.KeyInfo)
+	for key := range result {
+		if info := latest.KeyInfo[key]; !info.IsZero() {
+			resultInfo[key] = info
+		}
+	}
+	for _, key := range restored {
+		info := resultInfo[key]
+		info.Expires = sourceInfo[key].Expires
+		if info.IsZero() {
+			delete(resultInfo, key)
+		} else {
+			resultInfo[key] = info
+		}
+	}
+
+	changes := cryptutils.DiffEnvVersions(latestEnv, result)
+	sortDiff(&changes)
+
+	return &RollbackPlan{
+		SourceVersion: version,
+		LatestVersion: latest.Version,
+		Result:        result,
+		KeyInfo:       resultInfo,
+		Changes:       changes,
+	}, nil
+}
+
+func RollbackEnv(projectId uuid.UUID, email string, privateKey []byte, envName string, version int32, wrappedKey *cryptutils.WrappedKey, opts RollbackOptions) error {
+
+	plan, err := PlanRollback(projectId, email, privateKey, envName, version, wrappedKey, opts.Keys)
+	if err != nil {
+		return err
+	}
+
+	if plan.Empty() && !opts.Force {
+		return ErrNothingToRollback
+	}
+
+	return PushRollbackEnv(projectId, email, privateKey, envName, plan.Result, plan.KeyInfo, wrappedKey, version, plan.LatestVersion, opts.Message)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration extends time.ParseDuration with days (d) and weeks (w),
+// which rotation and expiry periods are usually given in, e.g. "90d".
+func ParseDuration(s string) (time.Duration, error) {
+	for unit, length := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, found := strings.CutSuffix(s, unit); found {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * length, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func parseMaxAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("max_age: %w", err)
+	}
+	return d, nil
+}
+
+// RotationPolicy is how long a key may keep the same value, and who is
+// responsible for rotating it. A zero MaxAge never goes stale.
+type RotationPolicy struct {
+	MaxAge time.Duration
+	Owner  string
+}
+
+// RotationPolicy returns the policy of key in envName: its own max_age and
+// owner, else the max_age of the environment, else that of the schema.
+func (s *Schema) RotationPolicy(envName, key string) RotationPolicy {
+	rules, _ := s.rulesFor(envName)
+
+	policy := RotationPolicy{MaxAge: s.maxAge}
+	if env := s.Envs[envName]; env != nil && env.maxAge != 0 {
+		policy.MaxAge = env.maxAge
+	}
+	if rule := rules[key]; rule != nil {
+		if rule.maxAge != 0 {
+			policy.MaxAge = rule.maxAge
+		}
+		policy.Owner = rule.Owner
+	}
+	return policy
+}
+
+// KeyAge is how long a key of the latest version has had its value.
+type KeyAge struct {
+	Key string
+	// Version is the first version with the current value, which may be
+	// older than a version that restored it.
+	Version   int32
+	ChangedAt time.Time
+	ChangedBy string
+	Age       time.Duration
+	Policy    RotationPolicy
+}
+
+// Overdue reports whether the key is older than its policy allows at now
+// plus within. Keys without a known age count as overdue.
+func (k KeyAge) Overdue(within time.Duration) bool {
+	if k.Policy.MaxAge == 0 {
+		return false
+	}
+	return k.ChangedAt.IsZero() || k.Age+within > k.Policy.MaxAge
+}
+
+// KeyAges computes, from the history, since when every key of the latest
+// version has had its value and how old that makes it at now. A value that
+// was rolled back to is as old as the first version that had it. Only the
+// PushedAt of versions counts, so the age is unknown for values that were
+// first pushed by older clients.
+func KeyAges(history []EnvVersion, now time.Time, policy func(key string) RotationPolicy) []KeyAge {
+	latest := latestVersion(history)
+	if latest == nil {
+		return nil
+	}
+
+	ages := make([]KeyAge, 0, len(latest.Env))
+	for key, value := range latest.Env {
+		first := latest
+		for i := range history {
+			if v, exists := history[i].Env[key]; exists && v == value {
+				first = &history[i]
+				break
+			}
+		}
+
+		age := KeyAge{
+			Key:       key,
+			Version:   first.Version,
+			ChangedAt: first.PushedAt,
+			ChangedBy: first.Metadata.Author,
+			Policy:    policy(key),
+		}
+		if !first.PushedAt.IsZero() {
+			age.Age = now.Sub(first.PushedAt)
+		}
+		ages = append(ages, age)
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i].Key < ages[j].Key })
+
+	return ages
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyAges(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return now.AddDate(0, 0, -n) }
+	version := func(n int32, pushedAt time.Time, env map[string]string) EnvVersion {
+		// The plaintext timestamp is always fresh, as a server hiding
+		// stale keys would make it.
+		return EnvVersion{Version: n, PushedAt: pushedAt, Env: env, Metadata: Metadata{Author: "a@example.com", Timestamp: now}}
+	}
+
+	history := []EnvVersion{
+		version(1, time.Time{}, map[string]string{"LEGACY": "1"}),
+		version(2, daysAgo(90), map[string]string{"LEGACY": "1", "OLD": "x", "ROLLED": "old"}),
+		version(3, daysAgo(50), map[string]string{"LEGACY": "1", "OLD": "x", "ROLLED": "new"}),
+		version(4, daysAgo(10), map[string]string{"LEGACY": "1", "OLD": "x", "ROLLED": "old", "NEW": "y"}),
+	}
+
+	policy := func(string) RotationPolicy { return RotationPolicy{MaxAge: 30 * 24 * time.Hour} }
+	ages := KeyAges(history, now, policy)
+
+	want := []struct {
+		key     string
+		version int32
+		days    int // -1 for unknown
+		overdue bool
+	}{
+		{"LEGACY", 1, -1, true},
+		{"NEW", 4, 10, false},
+		{"OLD", 2, 90, true},
+		{"ROLLED", 2, 90, true},
+	}
+	if len(ages) != len(want) {
+		t.Fatalf("got %d ages, want %d", len(ages), len(want))
+	}
+	for i, w := range want {
+		got := ages[i]
+		if got.Key != w.key || got.Version != w.version || got.Overdue(0) != w.overdue {
+			t.Errorf("got %s v%d overdue %v, want %s v%d overdue %v", got.Key, got.Version, got.Overdue(0), w.key, w.version, w.overdue)
+		}
+		switch {
+		case w.days < 0 && !got.ChangedAt.IsZero():
+			t.Errorf("%s: age should be unknown, got %v", got.Key, got.ChangedAt)
+		case w.days >= 0 && got.Age != time.Duration(w.days)*24*time.Hour:
+			t.Errorf("%s: got age %v, want %dd", got.Key, got.Age, w.days)
+		}
+	}
+}
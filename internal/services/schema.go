@@ -0,0 +1,407 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+// SchemaFileName is looked up in the working directory and its parents.
+// ENVCRYPT_SCHEMA names a different file, or disables validation when set
+// to "none".
+const SchemaFileName = ".envcrypt.schema"
+
+// Schema declares the keys environments may hold. It is read from JSON or
+// YAML, for example:
+//
+//	keys:
+//	  DATABASE_URL:
+//	    type: url
+//	    required: true
+//	  LOG_LEVEL:
+//	    values: [debug, info, warn, error]
+//	  SESSION_SECRET:
+//	    generate:
+//	      type: base64
+//	      length: 32
+//	  STRIPE_KEY:
+//	    max_age: 30d
+//	    owner: payments@example.com
+//	max_age: 90d
+//	envs:
+//	  Production:
+//	    allow_unknown: false
+//	    keys:
+//	      LOG_LEVEL:
+//	        values: [warn, error]
+//
+// Rules under envs override the fields they set for that environment only.
+type Schema struct {
+	Keys map[string]*KeyRule `json:"keys"`
+	// AllowUnknown permits keys the schema does not declare, the default.
+	AllowUnknown *bool `json:"allow_unknown"`
+	// MaxAge is how long any key may go without a new value, see env
+	// stale. Keys may set their own.
+	MaxAge string                `json:"max_age"`
+	Envs   map[string]*EnvSchema `json:"envs"`
+
+	maxAge time.Duration
+}
+
+type EnvSchema struct {
+	Keys         map[string]*KeyRule `json:"keys"`
+	AllowUnknown *bool               `json:"allow_unknown"`
+	MaxAge       string              `json:"max_age"`
+
+	maxAge time.Duration
+}
+
+// KeyRule constrains a single key. Keys are optional unless Required.
+type KeyRule struct {
+	// Type is one of string, int, bool, url, email, duration, base64, json.
+	Type     string `json:"type"`
+	Required *bool  `json:"required"`
+	// Pattern must match the whole value.
+	Pattern string         `json:"pattern"`
+	Values  []schemaScalar `json:"values"`
+	// Generate lets env generate create the value, see
+	// cryptutils.SecretPolicy.
+	Generate *cryptutils.SecretPolicy `json:"generate"`
+	// MaxAge and Owner are the rotation policy of the key: how long its
+	// value may stay unchanged, and who rotates it.
+	MaxAge string `json:"max_age"`
+	Owner  string `json:"owner"`
+
+	pattern *regexp.Regexp
+	maxAge  time.Duration
+}
+
+// schemaScalar accepts numbers and booleans in lists of allowed values,
+// which YAML turns unquoted values into.
+type schemaScalar string
+
+func (s *schemaScalar) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		return json.Unmarshal(data, (*string)(s))
+	}
+	*s = schemaScalar(data)
+	return nil
+}
+
+var schemaTypes = map[string]func(string) bool{
+	"string": func(string) bool { return true },
+	"int": func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	},
+	"bool": func(v string) bool {
+		switch strings.ToLower(v) {
+		case "true", "false", "1", "0", "yes", "no", "on", "off":
+			return true
+		}
+		return false
+	},
+	"url": func(v string) bool {
+		u, err := url.Parse(v)
+		return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != "")
+	},
+	"email": func(v string) bool {
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	},
+	"duration": func(v string) bool {
+		_, err := time.ParseDuration(v)
+		return err == nil
+	},
+	"base64": func(v string) bool {
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if _, err := enc.DecodeString(v); err == nil {
+				return true
+			}
+		}
+		return false
+	},
+	"json": func(v string) bool {
+		return json.Valid([]byte(v))
+	},
+}
+
+// ParseSchema reads a schema from JSON or, failing that, YAML.
+func ParseSchema(data []byte) (*Schema, error) {
+	if trimmed := bytes.TrimSpace(data); !bytes.HasPrefix(trimmed, []byte("{")) {
+		value, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var schema Schema
+	if err := dec.Decode(&schema); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if schema.maxAge, err = parseMaxAge(schema.MaxAge); err != nil {
+		return nil, err
+	}
+	rules := []map[string]*KeyRule{schema.Keys}
+	for name, env := range schema.Envs {
+		if env != nil {
+			if env.maxAge, err = parseMaxAge(env.MaxAge); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			rules = append(rules, env.Keys)
+		}
+	}
+	for _, keys := range rules {
+		for key, rule := range keys {
+			if rule == nil {
+				continue
+			}
+			if _, known := schemaTypes[rule.Type]; rule.Type != "" && !known {
+				return nil, fmt.Errorf("%s: unknown type %q", key, rule.Type)
+			}
+			if rule.Pattern != "" {
+				pattern, err := regexp.Compile(`^(?:` + rule.Pattern + `)$`)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				rule.pattern = pattern
+			}
+			if rule.Generate != nil {
+				if err := rule.Generate.Check(); err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+			}
+			if rule.maxAge, err = parseMaxAge(rule.MaxAge); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	return &schema, nil
+}
+
+// FindSchema loads the schema that applies to the working directory, or
+// returns nil if there is none.
+func FindSchema() (*Schema, string, error) {
+	path := os.Getenv("ENVCRYPT_SCHEMA")
+	if path == "none" {
+		return nil, "", nil
+	}
+
+	if path == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, "", err
+		}
+		for {
+			candidate := filepath.Join(dir, SchemaFileName)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return nil, "", nil
+			}
+			dir = parent
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	schema, err := ParseSchema(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	return schema, path, nil
+}
+
+// SchemaProblem is a single key failing its rule. It never includes the
+// value.
+type SchemaProblem struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// SchemaError lists every problem found in an environment.
+type SchemaError struct {
+	EnvName  string
+	Problems []SchemaProblem
+}
+
+func (e *SchemaError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s does not match the schema:", e.EnvName)
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s: %s", p.Key, p.Problem)
+	}
+	return b.String()
+}
+
+// rulesFor merges the overrides of envName into the base rules.
+func (s *Schema) rulesFor(envName string) (map[string]*KeyRule, bool) {
+	rules := make(map[string]*KeyRule, len(s.Keys))
+	for key, rule := range s.Keys {
+		if rule == nil {
+			rule = &KeyRule{}
+		}
+		copied := *rule
+		rules[key] = &copied
+	}
+	allowUnknown := s.AllowUnknown == nil || *s.AllowUnknown
+
+	env := s.Envs[envName]
+	if env == nil {
+		return rules, allowUnknown
+	}
+	if env.AllowUnknown != nil {
+		allowUnknown = *env.AllowUnknown
+	}
+	for key, override := range env.Keys {
+		rule, exists := rules[key]
+		if !exists {
+			rule = &KeyRule{}
+			rules[key] = rule
+		}
+		if override == nil {
+			continue
+		}
+		if override.Type != "" {
+			rule.Type = override.Type
+		}
+		if override.Required != nil {
+			rule.Required = override.Required
+		}
+		if override.Pattern != "" {
+			rule.Pattern, rule.pattern = override.Pattern, override.pattern
+		}
+		if override.Values != nil {
+			rule.Values = override.Values
+		}
+		if override.Generate != nil {
+			rule.Generate = override.Generate
+		}
+		if override.MaxAge != "" {
+			rule.MaxAge, rule.maxAge = override.MaxAge, override.maxAge
+		}
+		if override.Owner != "" {
+			rule.Owner = override.Owner
+		}
+	}
+
+	return rules, allowUnknown
+}
+
+// Validate checks env against the rules for envName and returns a
+// *SchemaError listing every problem.
+func (s *Schema) Validate(envName string, env map[string]string) error {
+	rules, allowUnknown := s.rulesFor(envName)
+
+	var problems []SchemaProblem
+	for key, rule := range rules {
+		value, exists := env[key]
+		required := rule.Required != nil && *rule.Required
+		switch {
+		case !exists && required:
+			problems = append(problems, SchemaProblem{key, "required but missing"})
+			continue
+		case !exists:
+			continue
+		case value == "" && required:
+			problems = append(problems, SchemaProblem{key, "required but empty"})
+			continue
+		}
+
+		if rule.Type != "" && !schemaTypes[rule.Type](value) {
+			problems = append(problems, SchemaProblem{key, "not a valid " + rule.Type})
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(value) {
+			problems = append(problems, SchemaProblem{key, fmt.Sprintf("does not match %s", rule.Pattern)})
+		}
+		if rule.Values != nil && !slices.Contains(rule.Values, schemaScalar(value)) {
+			allowed := make([]string, len(rule.Values))
+			for i, v := range rule.Values {
+				allowed[i] = string(v)
+			}
+			problems = append(problems, SchemaProblem{key, "not one of " + strings.Join(allowed, ", ")})
+		}
+	}
+
+	if !allowUnknown {
+		for key := range env {
+			if _, declared := rules[key]; !declared {
+				problems = append(problems, SchemaProblem{key, "not declared in the schema"})
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+	return &SchemaError{EnvName: envName, Problems: problems}
+}
+
+// GeneratePolicies returns how the keys of envName that declare a generate
+// rule are generated.
+func (s *Schema) GeneratePolicies(envName string) map[string]cryptutils.SecretPolicy {
+	rules, _ := s.rulesFor(envName)
+
+	policies := make(map[string]cryptutils.SecretPolicy)
+	for key, rule := range rules {
+		if rule.Generate != nil {
+			policies[key] = *rule.Generate
+		}
+	}
+	return policies
+}
+
+// checkSchema refuses env if it breaks the schema. Rollbacks only warn: they
+// restore a version that was accepted before and must work in an emergency,
+// even when the schema has changed since or cannot be read.
+func checkSchema(envName string, env map[string]string, rollback bool) error {
+	schema, path, err := FindSchema()
+	if err == nil && schema != nil {
+		err = schema.Validate(envName, env)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if rollback {
+		fmt.Fprintf(os.Stderr, "warning: rolling back anyway, %v\n", err)
+		return nil
+	}
+
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		return fmt.Errorf("refusing to push, %w\n(schema %s, set ENVCRYPT_SCHEMA=none to bypass)", err, path)
+	}
+	return err
+}
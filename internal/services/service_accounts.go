@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// Service accounts give CI pipelines read access to some environments of a
+// single project. Their X25519 keypair is generated by the member creating
+// them, who wraps the PMK for it exactly like for a user. The private key
+// never reaches the backend, it travels inside the token together with the
+// secret the backend checks on login.
+//
+// Revoking or rotating stops the backend from serving ciphertext to the old
+// token, but whoever held it may already have unwrapped the PMK.
+
+// servicePrincipalPrefix marks the identity service accounts use in place of
+// an email in requests.
+const servicePrincipalPrefix = "service:"
+
+// ServicePrincipal is the identity a service account sends as user email.
+func ServicePrincipal(id uuid.UUID) string {
+	return servicePrincipalPrefix + id.String()
+}
+
+// ParseServicePrincipal returns the service account id behind an identity,
+// if it is one.
+func ParseServicePrincipal(email string) (uuid.UUID, bool) {
+	rest, found := strings.CutPrefix(email, servicePrincipalPrefix)
+	if !found {
+		return uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(rest)
+	return id, err == nil
+}
+
+// ServiceAccountRequest creates a service account or, on rotation, replaces
+// its secret and keypair. Rotation ignores Id and Envs.
+type ServiceAccountRequest struct {
+	ProjectId uuid.UUID `json:"project_id"`
+	Email     string    `json:"user_email"`
+
+	Id     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Envs   []string  `json:"envs"`
+	Secret []byte    `json:"secret"`
+
+	PublicKey          []byte `json:"public_key"`
+	WrappedPMK         []byte `json:"wrapped_pmk"`
+	WrapNonce          []byte `json:"wrap_nonce"`
+	EphemeralPublicKey []byte `json:"ephemeral_public_key"`
+}
+
+type RevokeServiceAccountRequest struct {
+	ProjectId uuid.UUID `json:"project_id"`
+	Email     string    `json:"user_email"`
+
+	Name string `json:"name"`
+}
+
+type ListServiceAccountsRequest struct {
+	ProjectId uuid.UUID `json:"project_id"`
+	Email     string    `json:"user_email"`
+}
+
+type ServiceAccountInfo struct {
+	Id        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Envs      []string  `json:"envs"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at,omitzero"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+func (i *ServiceAccountInfo) Revoked() bool {
+	return !i.RevokedAt.IsZero()
+}
+
+type ServiceLoginRequest struct {
+	Id     uuid.UUID `json:"id"`
+	Secret []byte    `json:"secret"`
+}
+
+type ServiceLoginResponse struct {
+	Id          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	ProjectId   uuid.UUID `json:"project_id"`
+	ProjectName string    `json:"project_name"`
+	Envs        []string  `json:"envs"`
+
+	PublicKey          []byte `json:"public_key"`
+	WrappedPMK         []byte `json:"wrapped_pmk"`
+	WrapNonce          []byte `json:"wrap_nonce"`
+	EphemeralPublicKey []byte `json:"ephemeral_public_key"`
+}
+
+// serviceTokenPrefix makes tokens recognisable, e.g. by secret scanners.
+const serviceTokenPrefix = "envcrypt_sa_"
+
+// ServiceToken is everything a pipeline needs to read its environments.
+type ServiceToken struct {
+	Server     string    `json:"s,omitempty"`
+	Id         uuid.UUID `json:"i"`
+	Secret     []byte    `json:"c"`
+	PrivateKey []byte    `json:"k"`
+}
+
+func (t *ServiceToken) String() string {
+	data, _ := json.Marshal(t)
+	return serviceTokenPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseServiceToken(token string) (*ServiceToken, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(token), serviceTokenPrefix)
+	if !found {
+		return nil, errors.New("not an envcrypt service account token")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed service account token: %w", err)
+	}
+
+	var t ServiceToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("malformed service account token: %w", err)
+	}
+	if t.Id == uuid.Nil || len(t.Secret) == 0 || len(t.PrivateKey) != 32 {
+		return nil, errors.New("incomplete service account token")
+	}
+
+	return &t, nil
+}
+
+// CreateServiceAccount creates a service account with read access to envs
+// and returns its token. server is embedded in the token so it is the only
+// setting a pipeline needs.
+func CreateServiceAccount(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, name string, envs []string, server string) (*ServiceToken, error) {
+	if name == "" || len(envs) == 0 {
+		return nil, errors.New("a service account needs a name and at least one env")
+	}
+
+	req, token, err := newServiceAccountKey(privateKey, wrappedKey, server)
+	if err != nil {
+		return nil, err
+	}
+	req.ProjectId = projectId
+	req.Email = email
+	req.Id = token.Id
+	req.Name = name
+	req.Envs = envs
+
+	if err := DefaultBackend.CreateServiceAccount(*req); err != nil {
+		return nil, fmt.Errorf("creating service account failed: %w", err)
+	}
+
+	return token, nil
+}
+
+// RotateServiceAccount replaces the secret and keypair of a service account.
+// The previous token stops working.
+func RotateServiceAccount(projectId uuid.UUID, email string, privateKey []byte, wrappedKey *cryptutils.WrappedKey, name string, server string) (*ServiceToken, error) {
+	accounts, err := ListServiceAccounts(projectId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	var account *ServiceAccountInfo
+	for i := range accounts {
+		if accounts[i].Name == name {
+			account = &accounts[i]
+		}
+	}
+	if account == nil {
+		return nil, fmt.Errorf("service account %s not found", name)
+	}
+	if account.Revoked() {
+		return nil, fmt.Errorf("service account %s is revoked", name)
+	}
+
+	req, token, err := newServiceAccountKey(privateKey, wrappedKey, server)
+	if err != nil {
+		return nil, err
+	}
+	token.Id = account.Id
+	req.ProjectId = projectId
+	req.Email = email
+	req.Name = name
+
+	if err := DefaultBackend.RotateServiceAccount(*req); err != nil {
+		return nil, fmt.Errorf("rotating service account failed: %w", err)
+	}
+
+	return token, nil
+}
+
+func RevokeServiceAccount(projectId uuid.UUID, email, name string) error {
+	err := DefaultBackend.RevokeServiceAccount(RevokeServiceAccountRequest{
+		ProjectId: projectId,
+		Email:     email,
+		Name:      name,
+	})
+	if err != nil {
+		return fmt.Errorf("revoking service account failed: %w", err)
+	}
+
+	return nil
+}
+
+func ListServiceAccounts(projectId uuid.UUID, email string) ([]ServiceAccountInfo, error) {
+	return DefaultBackend.ListServiceAccounts(ListServiceAccountsRequest{
+		ProjectId: projectId,
+		Email:     email,
+	})
+}
+
+// ServiceLogin authenticates a token and returns the project it may read
+// along with the PMK wrapped for it.
+func ServiceLogin(token *ServiceToken) (*ServiceLoginResponse, *cryptutils.WrappedKey, error) {
+	resp, err := DefaultBackend.ServiceLogin(ServiceLoginRequest{Id: token.Id, Secret: token.Secret})
+	if err != nil {
+		return nil, nil, fmt.Errorf("service account login failed: %w", err)
+	}
+
+	publicKey, err := cryptutils.X25519PublicKey(token.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(publicKey, resp.PublicKey) {
+		return nil, nil, errors.New("service account key was rotated, this token is no longer valid")
+	}
+
+	wrappedKey := &cryptutils.WrappedKey{
+		WrappedPMK:       resp.WrappedPMK,
+		WrapNonce:        resp.WrapNonce,
+		WrapEphemeralPub: resp.EphemeralPublicKey,
+	}
+
+	return resp, wrappedKey, nil
+}
+
+// newServiceAccountKey generates a keypair and secret and wraps the PMK for
+// the new public key.
+func newServiceAccountKey(privateKey []byte, wrappedKey *cryptutils.WrappedKey, server string) (*ServiceAccountRequest, *ServiceToken, error) {
+	keyPair, err := cryptutils.GenerateEphemeralKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, nil, err
+	}
+
+	pmk, err := cryptutils.UnwrapPMK(wrappedKey, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pmk.Destroy()
+
+	// The keypair was generated right here, there is nothing to verify.
+	serviceKey, err := cryptutils.WrapPMKForUser(pmk.Bytes(), keyPair.PublicKey, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := &ServiceAccountRequest{
+		Secret:             secret,
+		PublicKey:          keyPair.PublicKey,
+		WrappedPMK:         serviceKey.WrappedPMK,
+		WrapNonce:          serviceKey.WrapNonce,
+		EphemeralPublicKey: serviceKey.WrapEphemeralPub,
+	}
+	token := &ServiceToken{
+		Server:     server,
+		Id:         uuid.New(),
+		Secret:     secret,
+		PrivateKey: keyPair.PrivateKey,
+	}
+
+	return req, token, nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// signingKeys holds the Ed25519 seeds of the users logged in by this
+// process, every version they push is signed with it.
+var signingKeys = struct {
+	sync.Mutex
+	seeds map[string][]byte
+}{seeds: make(map[string][]byte)}
+
+// useSigningKey makes pushes on behalf of email signed with seed.
+func useSigningKey(email string, seed []byte) {
+	signingKeys.Lock()
+	signingKeys.seeds[email] = seed
+	signingKeys.Unlock()
+}
+
+// ForgetSigningKey stops signing pushes on behalf of email, to be called
+// before the seed is destroyed.
+func ForgetSigningKey(email string) {
+	signingKeys.Lock()
+	delete(signingKeys.seeds, email)
+	signingKeys.Unlock()
+}
+
+func signingSeed(email string) []byte {
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+
+	return signingKeys.seeds[email]
+}
+
+func signVersion(projectId uuid.UUID, envName, email string, versionHash []byte) ([]byte, error) {
+	seed := signingSeed(email)
+	if seed == nil {
+		return nil, nil
+	}
+
+	return cryptutils.SignVersion(seed, projectId.String(), envName, email, versionHash)
+}
+
+// verifySignatures checks the signature of every version against the pinned
+// key of its author and marks the valid ones as Signed. Versions without an
+// author are reported, and so are unsigned ones whose author has a signing
+// key. Versions whose author's key cannot be looked up are reported as
+// unverified rather than forged, as nothing is known about their signature.
+// Neither is reported for versions whose hash is in accepted.
+func verifySignatures(projectId uuid.UUID, envName string, history []EnvVersion, accepted map[int32][]byte) []ChainProblem {
+	var problems []ChainProblem
+	report := func(envVersion *EnvVersion, kind, format string, args ...any) {
+		if hash, ok := accepted[envVersion.Version]; ok && acceptableKind(kind) && bytes.Equal(hash, envVersion.Hash) {
+			return
+		}
+		problems = append(problems, ChainProblem{Version: envVersion.Version, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	keys := make(map[string][]byte)
+
+	for i := range history {
+		envVersion := &history[i]
+		author := envVersion.Metadata.Author
+		if author == "" {
+			report(envVersion, "unsigned", "version has no author")
+			continue
+		}
+
+		key, cached := keys[author]
+		if !cached {
+			known, err := LookupUserKey(author)
+			var changed *KeyChangedError
+			switch {
+			case errors.As(err, &changed):
+				// Keep verifying against the key pinned before the change.
+				known = changed.Pinned
+			case err != nil:
+				report(envVersion, "unverified", "signing key of %s: %v", author, err)
+				continue
+			}
+			key = known.SigningPublicKey
+			keys[author] = key
+		}
+
+		if len(envVersion.Metadata.Signature) == 0 {
+			// Accounts created before versions were signed have no
+			// signing key and cannot sign.
+			if len(key) > 0 {
+				report(envVersion, "unsigned", "%s has a signing key but did not sign this version", author)
+			}
+			continue
+		}
+
+		if !cryptutils.VerifyVersionSignature(key, projectId.String(), envName, author, envVersion.Hash, envVersion.Metadata.Signature) {
+			report(envVersion, "forged", "signature does not match the pinned key of %s", author)
+			continue
+		}
+
+		envVersion.Signed = true
+	}
+
+	return problems
+}
+
+// acceptableKind reports whether AcceptVersion can accept a problem of kind.
+// Forged signatures and broken chains never can.
+func acceptableKind(kind string) bool {
+	return kind == "unsigned" || kind == "unverified"
+}
+
+// AcceptVersion stops reporting version as unsigned or unverified once its
+// author confirmed pushing it, so that one such version does not break every
+// later pull and push. The acceptance is kept on this machine and bound to
+// the version's hash, a different version served under the same number is
+// still reported.
+func AcceptVersion(projectId uuid.UUID, email string, privateKey []byte, envName string, version int32, wrappedKey *cryptutils.WrappedKey) error {
+
+	history, err := PullEnvHistory(projectId, email, privateKey, envName, wrappedKey)
+	var chainErr *ChainError
+	if err != nil && !errors.As(err, &chainErr) {
+		return err
+	}
+
+	acceptable := false
+	if chainErr != nil {
+		for _, problem := range chainErr.Problems {
+			if problem.Version != version {
+				continue
+			}
+			if !acceptableKind(problem.Kind) {
+				return fmt.Errorf("version %d of %s is %s, which cannot be accepted", version, envName, problem.Kind)
+			}
+			acceptable = true
+		}
+	}
+	if !acceptable {
+		return fmt.Errorf("version %d of %s is neither unsigned nor unverified", version, envName)
+	}
+
+	pin, err := loadChainPin(projectId, envName)
+	if err != nil {
+		return err
+	}
+	if pin == nil {
+		pin = &chainPin{}
+	}
+	if pin.Accepted == nil {
+		pin.Accepted = make(map[int32][]byte)
+	}
+	for _, envVersion := range history {
+		if envVersion.Version == version {
+			pin.Accepted[version] = envVersion.Hash
+		}
+	}
+
+	return saveChainPin(projectId, envName, pin)
+}
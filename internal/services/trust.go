@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/google/uuid"
+)
+
+// KnownKey is what this machine has pinned for a user. Keys are pinned the
+// first time they are seen and only become Verified once their fingerprint
+// was compared out of band.
+type KnownKey struct {
+	Id               uuid.UUID `json:"id"`
+	Email            string    `json:"email"`
+	PublicKey        []byte    `json:"public_key"`
+	SigningPublicKey []byte    `json:"signing_public_key,omitempty"`
+	FirstSeen        time.Time `json:"first_seen"`
+	Verified         bool      `json:"verified"`
+	VerifiedAt       time.Time `json:"verified_at,omitzero"`
+}
+
+func (k *KnownKey) Fingerprint() string {
+	return cryptutils.Fingerprint(k.PublicKey, k.SigningPublicKey)
+}
+
+// KeyChangedError is returned when the backend hands out keys for a user
+// that differ from the ones pinned on this machine.
+type KeyChangedError struct {
+	Pinned  *KnownKey
+	Current *KnownKey
+}
+
+func (e *KeyChangedError) Error() string {
+	return fmt.Sprintf("WARNING: the keys of %s changed!\n  pinned:  %s\n  current: %s\n"+
+		"Somebody may be substituting keys. Confirm the new fingerprint with %s, then run `trust accept %s`.",
+		e.Pinned.Email, e.Pinned.Fingerprint(), e.Current.Fingerprint(), e.Pinned.Email, e.Pinned.Email)
+}
+
+// LookupUserKey fetches the keys of email and checks them against the trust
+// store, pinning them on first use. A mismatch yields *KeyChangedError and
+// leaves the pin untouched.
+func LookupUserKey(email string) (*KnownKey, error) {
+	known, err := loadKnownKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := GetUserKeys(email)
+	if err != nil {
+		return nil, err
+	}
+	current := &KnownKey{
+		Id:               keys.Id,
+		Email:            email,
+		PublicKey:        keys.PublicKey,
+		SigningPublicKey: keys.SigningPublicKey,
+		FirstSeen:        time.Now().UTC(),
+	}
+
+	pinned, exists := known[email]
+	if !exists {
+		known[email] = current
+		return current, saveKnownKeys(known)
+	}
+
+	if !bytes.Equal(pinned.PublicKey, current.PublicKey) || !bytes.Equal(pinned.SigningPublicKey, current.SigningPublicKey) {
+		return nil, &KeyChangedError{Pinned: pinned, Current: current}
+	}
+
+	return pinned, nil
+}
+
+// pinOwnKey pins the logged in user's keys as verified. They were derived
+// from the password protected private keys, not taken from the backend.
+func pinOwnKey(id uuid.UUID, email string, publicKey, signingPublicKey []byte) error {
+	known, err := loadKnownKeys()
+	if err != nil {
+		return err
+	}
+
+	own := &KnownKey{
+		Id:               id,
+		Email:            email,
+		PublicKey:        publicKey,
+		SigningPublicKey: signingPublicKey,
+		FirstSeen:        time.Now().UTC(),
+		Verified:         true,
+		VerifiedAt:       time.Now().UTC(),
+	}
+	if pinned, exists := known[email]; exists {
+		if bytes.Equal(pinned.PublicKey, own.PublicKey) && bytes.Equal(pinned.SigningPublicKey, own.SigningPublicKey) && pinned.Verified {
+			return nil
+		}
+		own.FirstSeen = pinned.FirstSeen
+	}
+
+	known[email] = own
+	return saveKnownKeys(known)
+}
+
+// VerifyUserKey marks the pinned keys of email as verified.
+func VerifyUserKey(email string) (*KnownKey, error) {
+	known, err := loadKnownKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, exists := known[email]
+	if !exists {
+		return nil, fmt.Errorf("no key pinned for %s, look it up first", email)
+	}
+
+	pinned.Verified = true
+	pinned.VerifiedAt = time.Now().UTC()
+
+	return pinned, saveKnownKeys(known)
+}
+
+// AcceptUserKey replaces the pin of email with the keys the backend returns
+// now. The new keys start out unverified.
+func AcceptUserKey(email string) (*KnownKey, error) {
+	known, err := loadKnownKeys()
+	if err != nil {
+		return nil, err
+	}
+	delete(known, email)
+	if err := saveKnownKeys(known); err != nil {
+		return nil, err
+	}
+
+	return LookupUserKey(email)
+}
+
+// KnownKeys lists the trust store sorted by email.
+func KnownKeys() ([]*KnownKey, error) {
+	known, err := loadKnownKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*KnownKey, 0, len(known))
+	for _, key := range known {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Email < keys[j].Email })
+
+	return keys, nil
+}
+
+func knownKeysPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "known_keys.json"), nil
+}
+
+func loadKnownKeys() (map[string]*KnownKey, error) {
+	path, err := knownKeysPath()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*KnownKey)
+	if err := readJSON(path, &known); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return known, nil
+}
+
+func saveKnownKeys(known map[string]*KnownKey) error {
+	path, err := knownKeysPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(known, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
@@ -2,10 +2,7 @@ package services
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 
 	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
 	"github.com/google/uuid"
@@ -19,6 +16,15 @@ type CreateRequestBody struct {
 	EncryptedUserPrivateKey []byte `json:"encrypted_user_private_key"`
 	PrivateKeySalt          []byte `json:"private_key_salt"`
 	PrivateKeyNonce         []byte `json:"private_key_nonce"`
+
+	SigningPublicKey    []byte `json:"signing_public_key"`
+	EncryptedSigningKey []byte `json:"encrypted_signing_key"`
+	SigningKeySalt      []byte `json:"signing_key_salt"`
+	SigningKeyNonce     []byte `json:"signing_key_nonce"`
+
+	// IdentityType is cryptutils.SSHIdentityType for accounts whose keys
+	// are derived from an SSH key, which carry no encrypted private keys.
+	IdentityType string `json:"identity_type,omitempty"`
 }
 
 type UserBody struct {
@@ -29,6 +35,27 @@ type UserBody struct {
 	PrivateKeySalt          []byte                    `json:"private_key_salt"`
 	PrivateKeyNonce         []byte                    `json:"private_key_nonce"`
 	ArgonParams             cryptutils.Argon2idParams `json:"argon_params"`
+
+	// Accounts created before versions were signed have no signing key.
+	SigningPublicKey    []byte `json:"signing_public_key,omitempty"`
+	EncryptedSigningKey []byte `json:"encrypted_signing_key,omitempty"`
+	SigningKeySalt      []byte `json:"signing_key_salt,omitempty"`
+	SigningKeyNonce     []byte `json:"signing_key_nonce,omitempty"`
+
+	IdentityType string `json:"identity_type,omitempty"`
+}
+
+type GetUserKeysRequest struct {
+	Email string `json:"email"`
+}
+
+// UserKeysResponse holds the public keys of any user, as needed to share a
+// project with them or to verify the versions they pushed.
+type UserKeysResponse struct {
+	Id               uuid.UUID `json:"id"`
+	Email            string    `json:"email"`
+	PublicKey        []byte    `json:"public_key"`
+	SigningPublicKey []byte    `json:"signing_public_key,omitempty"`
 }
 
 type LoginRequestBody struct {
@@ -45,7 +72,8 @@ func Register(email, password string) error {
 	if err != nil {
 		return err
 	}
-	
+	defer keypair.Destroy()
+
 	var RequestBody = CreateRequestBody{
 		Email:                   email,
 		Password:                password,
@@ -53,25 +81,36 @@ func Register(email, password string) error {
 		EncryptedUserPrivateKey: keypair.EncKey.EncryptedUserPrivateKey,
 		PrivateKeySalt:          keypair.EncKey.PrivateKeySalt,
 		PrivateKeyNonce:         keypair.EncKey.PrivateKeyNonce,
+		SigningPublicKey:        keypair.SigningPublicKey,
+		EncryptedSigningKey:     keypair.EncSigningKey.EncryptedUserPrivateKey,
+		SigningKeySalt:          keypair.EncSigningKey.PrivateKeySalt,
+		SigningKeyNonce:         keypair.EncSigningKey.PrivateKeyNonce,
 	}
 
-	requestBody, err := json.Marshal(RequestBody)
-	if err != nil {
-		return err
+	if err := DefaultBackend.Register(RequestBody); err != nil {
+		return fmt.Errorf("registration failed: %w", err)
 	}
 
-	resp, err := http.Post("http://localhost:8080/users/create", "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	fmt.Println("Registered", email)
+	return nil
+}
 
-	responseBody, err := ioutil.ReadAll(resp.Body)
+// RegisterSSH creates an account whose keys are derived from an ssh-ed25519
+// key, see cryptutils.SSHKeyPair. Only the public keys are sent, the
+// password just authenticates to the backend.
+func RegisterSSH(email, password string, publicKey, signingPublicKey []byte) error {
+	err := DefaultBackend.Register(CreateRequestBody{
+		Email:            email,
+		Password:         password,
+		PublicKey:        publicKey,
+		SigningPublicKey: signingPublicKey,
+		IdentityType:     cryptutils.SSHIdentityType,
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("registration failed: %w", err)
 	}
 
-	fmt.Println(string(responseBody))
+	fmt.Println("Registered", email, "with an SSH key")
 	return nil
 }
 
@@ -81,36 +120,109 @@ func Login(email, password string) (*cryptutils.KeyPair, *uuid.UUID, error) {
 		Email:    email,
 		Password: password,
 	}
-	requestBody, err := json.Marshal(RequestBody)
 
-	resp, err := http.Post("http://localhost:8080/users/login", "application/json", bytes.NewBuffer(requestBody))
+	user, err := DefaultBackend.Login(RequestBody)
+	if err != nil {
+		return nil, nil, fmt.Errorf("login failed: %w", err)
+	}
+	if user.IdentityType == cryptutils.SSHIdentityType {
+		return nil, nil, fmt.Errorf("%s logs in with an SSH key, pass -ssh-key", email)
+	}
+
+	encryptedKey := &cryptutils.EncryptedPrivateKey{
+		EncryptedUserPrivateKey: user.EncryptedUserPrivateKey,
+		PrivateKeySalt:          user.PrivateKeySalt,
+		PrivateKeyNonce:         user.PrivateKeyNonce,
+	}
+	privateKey, err := cryptutils.DecryptPrivateKey(encryptedKey, password, &user.ArgonParams)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer resp.Body.Close()
 
-	var LoginResponse LoginResponseBody
+	var signingKey *cryptutils.SecureBuffer
+	var encSigningKey cryptutils.EncryptedPrivateKey
+	if len(user.EncryptedSigningKey) > 0 {
+		encSigningKey = cryptutils.EncryptedPrivateKey{
+			EncryptedUserPrivateKey: user.EncryptedSigningKey,
+			PrivateKeySalt:          user.SigningKeySalt,
+			PrivateKeyNonce:         user.SigningKeyNonce,
+		}
+		signingKey, err = cryptutils.DecryptPrivateKey(&encSigningKey, password, &user.ArgonParams)
+		if err != nil {
+			privateKey.Destroy()
+			return nil, nil, err
+		}
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&LoginResponse)
+	keyPair := &cryptutils.KeyPair{
+		EncKey:        *encryptedKey,
+		EncSigningKey: encSigningKey,
+	}
+	keyPair.SetPrivateKeys(privateKey, signingKey)
+
+	// Never trust the public keys the backend sends for our own account,
+	// derive them from the private keys instead.
+	keyPair.PublicKey, err = cryptutils.X25519PublicKey(keyPair.PrivateKey)
 	if err != nil {
+		keyPair.Destroy()
 		return nil, nil, err
 	}
+	if signingKey != nil {
+		keyPair.SigningPublicKey = cryptutils.SigningPublicKey(keyPair.SigningPrivateKey)
+	}
 
-	encryptedKey := &cryptutils.EncryptedPrivateKey{
-		EncryptedUserPrivateKey: LoginResponse.User.EncryptedUserPrivateKey,
-		PrivateKeySalt:          LoginResponse.User.PrivateKeySalt,
-		PrivateKeyNonce:         LoginResponse.User.PrivateKeyNonce,
+	if err := useOwnKeys(email, user, keyPair); err != nil {
+		keyPair.Destroy()
+		return nil, nil, err
 	}
-	privateKey, err := cryptutils.DecryptPrivateKey(encryptedKey, password, &LoginResponse.User.ArgonParams)
+
+	return keyPair, &user.Id, nil
+}
+
+// LoginSSH logs into an account registered with RegisterSSH. keyPair comes
+// from cryptutils.SSHKeyPair and is returned on success.
+func LoginSSH(email, password string, keyPair *cryptutils.KeyPair) (*cryptutils.KeyPair, *uuid.UUID, error) {
+	user, err := DefaultBackend.Login(LoginRequestBody{Email: email, Password: password})
 	if err != nil {
+		return nil, nil, fmt.Errorf("login failed: %w", err)
+	}
+	if user.IdentityType != cryptutils.SSHIdentityType {
+		return nil, nil, fmt.Errorf("%s does not log in with an SSH key", email)
+	}
+	if !bytes.Equal(keyPair.SigningPublicKey, user.SigningPublicKey) {
+		return nil, nil, fmt.Errorf("%s registered with a different SSH key", email)
+	}
+
+	if err := useOwnKeys(email, user, keyPair); err != nil {
 		return nil, nil, err
 	}
 
-	keyPair := &cryptutils.KeyPair{
-		PublicKey:  LoginResponse.User.PublicKey,
-		PrivateKey: privateKey,
-		EncKey:     *encryptedKey,
+	return keyPair, &user.Id, nil
+}
+
+// useOwnKeys checks that the backend returned the account of email and that
+// the public keys it holds match keyPair, derived locally, then pins them and
+// signs pushes with the signing key.
+func useOwnKeys(email string, user *UserBody, keyPair *cryptutils.KeyPair) error {
+	if user.Email != email {
+		return fmt.Errorf("logged in as %s but the backend returned the account of %q", email, user.Email)
+	}
+	if !bytes.Equal(keyPair.PublicKey, user.PublicKey) || (len(user.SigningPublicKey) > 0 && !bytes.Equal(keyPair.SigningPublicKey, user.SigningPublicKey)) {
+		return fmt.Errorf("public keys served for %s do not match the private keys", email)
+	}
+
+	if err := pinOwnKey(user.Id, email, keyPair.PublicKey, keyPair.SigningPublicKey); err != nil {
+		return err
+	}
+
+	if keyPair.SigningPrivateKey != nil {
+		useSigningKey(email, keyPair.SigningPrivateKey)
 	}
 
-	return keyPair, &LoginResponse.User.Id, nil
+	return nil
+}
+
+// GetUserKeys looks up the public keys of a user.
+func GetUserKeys(email string) (*UserKeysResponse, error) {
+	return DefaultBackend.GetUserKeys(GetUserKeysRequest{Email: email})
 }
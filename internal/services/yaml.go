@@ -0,0 +1,309 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML schema files need: block mappings,
+// block sequences of scalars, flow sequences of scalars, plain and quoted
+// scalars and comments. The result is made of map[string]any, []any,
+// string, bool, int64, float64 and nil, ready to be re-encoded as JSON.
+// Anchors, tags, block scalars and flow mappings are refused rather than
+// misread.
+func parseYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(text)
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "" || (indent == 0 && (text == "---" || text == "...")) {
+			continue
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: indent, text: text})
+	}
+
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	value, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+
+	return value, nil
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	line := p.lines[min(p.pos, len(p.lines)-1)]
+	return fmt.Errorf("line %d: %s", line.number, fmt.Sprintf(format, args...))
+}
+
+func (p *yamlParser) more(indent int) bool {
+	return p.pos < len(p.lines) && p.lines[p.pos].indent == indent
+}
+
+func (p *yamlParser) block(indent int) (any, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.more(indent) && isYAMLSequenceItem(p.lines[p.pos].text) {
+		rest := strings.TrimSpace(p.lines[p.pos].text[1:])
+		if _, _, isKey := splitYAMLKey(rest); isKey {
+			return nil, p.errorf("mappings inside sequences are not supported")
+		}
+		p.pos++
+
+		var item any
+		var err error
+		if rest == "" {
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.block(p.lines[p.pos].indent)
+			}
+		} else {
+			item, err = p.inline(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.more(indent) {
+		key, rest, ok := splitYAMLKey(p.lines[p.pos].text)
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, exists := m[key]; exists {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		var value any
+		var err error
+		switch {
+		case rest != "":
+			value, err = p.inline(rest)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			value, err = p.block(p.lines[p.pos].indent)
+		case p.more(indent) && isYAMLSequenceItem(p.lines[p.pos].text):
+			// A sequence may sit at the indentation of its key.
+			value, err = p.sequence(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+
+	return m, nil
+}
+
+// inline parses a value written on the same line as its key or dash.
+func (p *yamlParser) inline(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, p.errorf("unterminated flow sequence")
+		}
+		items := []any{}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return items, nil
+		}
+		for _, part := range splitYAMLFlow(inner) {
+			part = strings.TrimSpace(part)
+			if part == "" || strings.ContainsAny(part[:1], "[{") {
+				return nil, p.errorf("nested flow collections are not supported")
+			}
+			item, err := parseYAMLScalar(part)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.ContainsAny(text[:1], "{&*!|>"):
+		return nil, p.errorf("unsupported YAML syntax %q", text[:1])
+	}
+
+	value, err := parseYAMLScalar(text)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return value, nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" and "key:", where key may be quoted.
+func splitYAMLKey(text string) (string, string, bool) {
+	var key, rest string
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := yamlQuoteEnd(text)
+		if end < 0 {
+			return "", "", false
+		}
+		unquoted, err := parseYAMLScalar(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		key, rest = unquoted.(string), text[end+1:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(text, ": ")
+		switch {
+		case i >= 0:
+			key, rest = text[:i], text[i+1:]
+		case strings.HasSuffix(text, ":"):
+			key = text[:len(text)-1]
+		default:
+			return "", "", false
+		}
+	}
+
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), key != ""
+}
+
+// yamlQuoteEnd returns the index of the quote closing the one text starts
+// with, or -1.
+func yamlQuoteEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripYAMLComment removes a # comment, which must start the line or follow
+// whitespace, outside of quotes.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" [,:", rune(text[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// splitYAMLFlow splits the inside of a flow sequence at commas outside of
+// quotes.
+func splitYAMLFlow(text string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// parseYAMLScalar types a scalar following the YAML 1.2 core schema.
+func parseYAMLScalar(text string) (any, error) {
+	switch text[0] {
+	case '"':
+		if yamlQuoteEnd(text) != len(text)-1 {
+			return nil, fmt.Errorf("malformed quoted string %s", text)
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("malformed quoted string %s", text)
+		}
+		return s, nil
+	case '\'':
+		if yamlQuoteEnd(text) != len(text)-1 {
+			return nil, fmt.Errorf("malformed quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if strings.ContainsAny(text[:1], "0123456789+-.") {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil && !strings.ContainsAny(text, "xXpP_iInN") {
+			return f, nil
+		}
+	}
+
+	return text, nil
+}
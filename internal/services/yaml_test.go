@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want any
+	}{
+		{"empty", "", map[string]any{}},
+		{"only comments", "# nothing\n   # here\n---\n", map[string]any{}},
+		{
+			name: "nested mappings",
+			yaml: "keys:\n  PORT:\n    type: int\n    required: true\n  HOST:\n    type: string\nmax_age: 90d\n",
+			want: map[string]any{
+				"keys": map[string]any{
+					"PORT": map[string]any{"type": "int", "required": true},
+					"HOST": map[string]any{"type": "string"},
+				},
+				"max_age": "90d",
+			},
+		},
+		{
+			name: "comments",
+			yaml: "# schema\na: 1 # trailing\nb: x#not a comment\nc: \"# quoted\" # comment\nd: '#' \n",
+			want: map[string]any{"a": int64(1), "b": "x#not a comment", "c": "# quoted", "d": "#"},
+		},
+		{
+			name: "scalars",
+			yaml: "i: -42\nf: 1.5\nt: true\nF: FALSE\nn: ~\nnull: null\nhex: 0x1f\ns: 1.2.3\nempty:\nversion: 1e3\n",
+			want: map[string]any{
+				"i": int64(-42), "f": 1.5, "t": true, "F": false, "n": nil, "null": nil,
+				"hex": "0x1f", "s": "1.2.3", "empty": nil, "version": 1000.0,
+			},
+		},
+		{
+			name: "quoting",
+			yaml: "a: \"tab\\tand \\\"quotes\\\"\"\nb: 'it''s'\nc: \"true\"\nd: '42'\ne: \"a: b\"\n\"quoted key\": 1\n'single: key': 2\n",
+			want: map[string]any{
+				"a": "tab\tand \"quotes\"", "b": "it's", "c": "true", "d": "42", "e": "a: b",
+				"quoted key": int64(1), "single: key": int64(2),
+			},
+		},
+		{
+			name: "flow sequences",
+			yaml: "a: [debug, info, 'warn, error', \"x]y\"]\nb: []\nc: [1, true, ~]\n",
+			want: map[string]any{
+				"a": []any{"debug", "info", "warn, error", "x]y"},
+				"b": []any{},
+				"c": []any{int64(1), true, nil},
+			},
+		},
+		{
+			name: "block sequences",
+			yaml: "a:\n  - one\n  - 2\nb:\n- at key indentation\n- 'quoted'\n",
+			want: map[string]any{
+				"a": []any{"one", int64(2)},
+				"b": []any{"at key indentation", "quoted"},
+			},
+		},
+		{
+			name: "windows line endings",
+			yaml: "a: 1\r\nb:\r\n  c: x\r\n",
+			want: map[string]any{"a": int64(1), "b": map[string]any{"c": "x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs"},
+		{"anchor", "a: &x 1\n", "line 1: unsupported YAML syntax \"&\""},
+		{"alias", "a: *x\n", "unsupported YAML syntax \"*\""},
+		{"tag", "a: !!str 1\n", "unsupported YAML syntax \"!\""},
+		{"literal block", "a: |\n  text\n", "unsupported YAML syntax \"|\""},
+		{"folded block", "a: >\n  text\n", "unsupported YAML syntax \">\""},
+		{"flow mapping", "a: {b: 1}\n", "unsupported YAML syntax \"{\""},
+		{"nested flow", "a: [[1], 2]\n", "nested flow collections"},
+		{"empty flow item", "a: [1,,2]\n", "nested flow collections"},
+		{"unterminated flow", "a: [1, 2\n", "unterminated flow sequence"},
+		{"mapping in sequence", "a:\n  - b: 1\n", "mappings inside sequences"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", "line 3: duplicate key \"a\""},
+		{"not a mapping", "a: 1\njust text\n", "line 2: expected key: value"},
+		{"bad indentation", "a:\n    b: 1\n  c: 2\n", "line 3: unexpected indentation"},
+		{"unterminated quote", "a: \"open\n", "malformed quoted string"},
+		{"text after quote", "a: 'x' y\n", "malformed quoted string"},
+		{"key without space", "a:1\n", "expected key: value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.yaml))
+			if err == nil {
+				t.Fatalf("accepted as %#v", got)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseSchemaYAMLOverrides(t *testing.T) {
+	schema, err := ParseSchema([]byte(`
+keys:
+  LOG_LEVEL:
+    values: [debug, info, warn, error]
+  PORT:
+    type: int
+    required: true
+max_age: 90d
+envs:
+  Production:
+    allow_unknown: false
+    max_age: 30d
+    keys:
+      LOG_LEVEL:
+        values: [warn, error]
+      STRIPE_KEY:
+        max_age: 7d
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env     string
+		values  map[string]string
+		problem string
+	}{
+		{"Testing", map[string]string{"PORT": "80", "LOG_LEVEL": "debug", "EXTRA": "x"}, ""},
+		{"Testing", map[string]string{"LOG_LEVEL": "info"}, "PORT"},
+		{"Production", map[string]string{"PORT": "80", "LOG_LEVEL": "error"}, ""},
+		{"Production", map[string]string{"PORT": "80", "LOG_LEVEL": "debug"}, "LOG_LEVEL"},
+		{"Production", map[string]string{"PORT": "80", "EXTRA": "x"}, "EXTRA"},
+		{"Production", map[string]string{"PORT": "http"}, "PORT"},
+	}
+	for _, tt := range tests {
+		err := schema.Validate(tt.env, tt.values)
+		var schemaErr *SchemaError
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("%s %v: %v", tt.env, tt.values, err)
+		case tt.problem != "" && !errors.As(err, &schemaErr):
+			t.Errorf("%s %v: got %v, want a schema error", tt.env, tt.values, err)
+		case tt.problem != "" && !strings.Contains(err.Error(), tt.problem+":"):
+			t.Errorf("%s %v: got %v, want a problem with %s", tt.env, tt.values, err, tt.problem)
+		}
+	}
+
+	for _, tt := range []struct {
+		env, key string
+		days     int
+	}{
+		{"Testing", "PORT", 90},
+		{"Production", "PORT", 30},
+		{"Production", "STRIPE_KEY", 7},
+	} {
+		if got := schema.RotationPolicy(tt.env, tt.key).MaxAge.Hours() / 24; int(got) != tt.days {
+			t.Errorf("max age of %s in %s: got %vd, want %dd", tt.key, tt.env, got, tt.days)
+		}
+	}
+}
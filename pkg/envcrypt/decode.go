@@ -0,0 +1,110 @@
+package envcrypt
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decode stores secrets in the fields of the struct v points to. Fields are
+// selected with a tag naming the key, optionally followed by ",required":
+//
+//	Port    int      `envcrypt:"PORT"`
+//	APIKey  string   `envcrypt:"API_KEY,required"`
+//	Origins []string `envcrypt:"ALLOWED_ORIGINS"`
+//
+// Supported field types are string, bool, integers, floats, time.Duration
+// and slices of those, which are split at commas. Fields without a tag and
+// keys that are not set are left alone.
+func (s Secrets) Decode(v any) error {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return errors.New("envcrypt: Decode needs a non-nil pointer to a struct")
+	}
+	target := ptr.Elem()
+
+	var missing []string
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		tag, ok := field.Tag.Lookup("envcrypt")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		key, options, _ := strings.Cut(tag, ",")
+		value, set := s[key]
+		if !set {
+			if options == "required" {
+				missing = append(missing, key)
+			}
+			continue
+		}
+
+		if err := setField(target.Field(i), value); err != nil {
+			return fmt.Errorf("envcrypt: %s into %s: %w", key, field.Name, err)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("envcrypt: required keys not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		parts := strings.Split(value, ",")
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setField(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
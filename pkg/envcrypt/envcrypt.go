@@ -0,0 +1,264 @@
+// Package envcrypt loads the secrets of an envcrypt environment into a Go
+// program at startup, authenticated as a service account:
+//
+//	secrets, err := envcrypt.Load(ctx, "shop", "Production")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	var cfg struct {
+//		DatabaseURL string        `envcrypt:"DATABASE_URL,required"`
+//		Timeout     time.Duration `envcrypt:"TIMEOUT"`
+//	}
+//	err = secrets.Decode(&cfg)
+//
+// The token is read from ENVCRYPT_TOKEN unless WithToken is given. Versions
+// are decrypted in process and checked against the hash chain and the
+// signatures of their authors exactly like the envcrypt CLI does.
+package envcrypt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+	"github.com/envcrypts/envcrypt_cli/internal/services"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// Secrets maps env keys to their values.
+type Secrets map[string]string
+
+// Get returns the value of key and whether it is set.
+func (s Secrets) Get(key string) (string, bool) {
+	value, ok := s[key]
+	return value, ok
+}
+
+// Setenv adds every secret to the process environment. Variables that are
+// already set win, so the environment can override single secrets.
+func (s Secrets) Setenv() error {
+	for key, value := range s {
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type config struct {
+	server   string
+	token    string
+	offline  bool
+	warnings io.Writer
+	limits   string
+	override Limits
+}
+
+// Limits bound what loading a version may allocate. Zero fields keep the
+// defaults, or the values from ENVCRYPT_LIMITS, which takes the same form
+// as for the envcrypt CLI.
+type Limits struct {
+	// MaxEnvSize is the size of an env in .env form, after decompression.
+	MaxEnvSize     int
+	MaxKeys        int
+	MaxKeyLength   int
+	MaxValueLength int
+	// MaxResponseSize bounds every response read from the server.
+	MaxResponseSize int64
+}
+
+// Option configures Load and Watch.
+type Option func(*config)
+
+// WithServer overrides ENVCRYPT_SERVER and the server stored in the token.
+func WithServer(server string) Option {
+	return func(c *config) { c.server = server }
+}
+
+// WithToken sets the service account token instead of ENVCRYPT_TOKEN.
+func WithToken(token string) Option {
+	return func(c *config) { c.token = token }
+}
+
+// WithOffline only uses versions cached by earlier loads. Without it the
+// cache is still used when the server is unreachable.
+func WithOffline() Option {
+	return func(c *config) { c.offline = true }
+}
+
+// WithWarnings receives warnings such as the use of stale cached versions.
+// The default is os.Stderr.
+func WithWarnings(w io.Writer) Option {
+	return func(c *config) { c.warnings = w }
+}
+
+// WithLimits raises or lowers the limits on what a load may allocate.
+func WithLimits(limits Limits) Option {
+	return func(c *config) { c.override = limits }
+}
+
+// mu serialises every call into the services package, which keeps its
+// backend in a package variable.
+var mu sync.Mutex
+
+// Load returns the latest version of env in project.
+func Load(ctx context.Context, project, env string, opts ...Option) (Secrets, error) {
+	secrets, _, err := load(ctx, project, env, opts)
+	return secrets, err
+}
+
+// LoadEnv loads env and adds it to the process environment, see
+// Secrets.Setenv.
+func LoadEnv(ctx context.Context, project, env string, opts ...Option) error {
+	secrets, err := Load(ctx, project, env, opts...)
+	if err != nil {
+		return err
+	}
+
+	return secrets.Setenv()
+}
+
+type loadResult struct {
+	secrets Secrets
+	version int32
+	err     error
+}
+
+// load runs a load in the background so ctx can abandon it. The services
+// package does not take contexts, an abandoned load finishes on its own.
+func load(ctx context.Context, project, env string, opts []Option) (Secrets, int32, error) {
+	cfg := config{
+		server: os.Getenv("ENVCRYPT_SERVER"),
+		token:  os.Getenv("ENVCRYPT_TOKEN"),
+		limits: os.Getenv("ENVCRYPT_LIMITS"),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	done := make(chan loadResult, 1)
+	go func() {
+		secrets, version, err := loadLocked(&cfg, project, env)
+		done <- loadResult{secrets, version, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case result := <-done:
+		return result.secrets, result.version, result.err
+	}
+}
+
+func loadLocked(cfg *config, project, env string) (Secrets, int32, error) {
+	if cfg.token == "" {
+		return nil, 0, errors.New("envcrypt: no service account token, set ENVCRYPT_TOKEN")
+	}
+	token, err := services.ParseServiceToken(cfg.token)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envcrypt: %w", err)
+	}
+	privateKey := cryptutils.SecureBufferFrom(token.PrivateKey)
+	defer privateKey.Destroy()
+	token.PrivateKey = privateKey.Bytes()
+
+	server := cfg.server
+	if server == "" {
+		server = token.Server
+	}
+	if server == "" {
+		server = defaultServer
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	previousLimits := services.CurrentLimits()
+	limits, err := services.ParseLimits(cfg.limits, previousLimits)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envcrypt: ENVCRYPT_LIMITS: %w", err)
+	}
+	cfg.override.apply(&limits)
+	services.SetLimits(limits)
+	defer services.SetLimits(previousLimits)
+
+	backend, err := services.OpenBackend(server)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envcrypt: %w", err)
+	}
+	if _, remote := backend.(*services.HTTPBackend); remote {
+		cached, err := services.NewCachingBackend(backend, server, cfg.offline)
+		if err != nil {
+			return nil, 0, fmt.Errorf("envcrypt: %w", err)
+		}
+		cached.Warnings = cfg.warnings
+		backend = cached
+	}
+
+	previous := services.DefaultBackend
+	services.DefaultBackend = backend
+	defer func() { services.DefaultBackend = previous }()
+
+	account, wrappedKey, err := services.ServiceLogin(token)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envcrypt: %w", err)
+	}
+	if project != account.ProjectName {
+		return nil, 0, fmt.Errorf("envcrypt: service account %s belongs to project %s, not %s", account.Name, account.ProjectName, project)
+	}
+	if !slices.Contains(account.Envs, env) {
+		return nil, 0, fmt.Errorf("envcrypt: service account %s cannot read %s", account.Name, env)
+	}
+
+	latest, err := services.PullLatestVersion(account.ProjectId, services.ServicePrincipal(account.Id), privateKey.Bytes(), env, wrappedKey)
+	if err != nil {
+		return nil, 0, fmt.Errorf("envcrypt: %w", err)
+	}
+	if latest == nil {
+		return nil, 0, fmt.Errorf("envcrypt: %s has no versions yet", env)
+	}
+
+	// Expired values are still returned, the program may have a grace
+	// period the expiry date does not know about.
+	values, expired := services.WithoutExpired(latest, latest.Env, time.Now(), false)
+	if len(expired) > 0 {
+		warnings := cfg.warnings
+		if warnings == nil {
+			warnings = os.Stderr
+		}
+		for _, k := range expired {
+			fmt.Fprintf(warnings, "envcrypt: warning: %s expired %s\n", k.Key, k.Expires.Format(time.RFC3339))
+		}
+	}
+
+	return Secrets(values), latest.Version, nil
+}
+
+func (l Limits) apply(limits *services.Limits) {
+	if l.MaxEnvSize > 0 {
+		limits.MaxEnvSize = l.MaxEnvSize
+	}
+	if l.MaxKeys > 0 {
+		limits.MaxKeys = l.MaxKeys
+	}
+	if l.MaxKeyLength > 0 {
+		limits.MaxKeyLength = l.MaxKeyLength
+	}
+	if l.MaxValueLength > 0 {
+		limits.MaxValueLength = l.MaxValueLength
+	}
+	if l.MaxResponseSize > 0 {
+		limits.MaxResponseSize = l.MaxResponseSize
+	}
+}
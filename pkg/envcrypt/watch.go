@@ -0,0 +1,85 @@
+package envcrypt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	cryptutils "github.com/envcrypts/envcrypt_cli/internal/crypto"
+)
+
+// Change describes a new version found by Watch. Key lists are sorted.
+type Change struct {
+	Secrets  Secrets
+	Version  int32
+	Added    []string
+	Modified []string
+	Removed  []string
+}
+
+// Watch loads env like Load and then checks for a new version every
+// interval until ctx is done, calling onChange from its own goroutine for
+// each one. Failed refreshes keep the previous secrets and are reported
+// like other warnings, see WithWarnings. interval must be positive.
+func Watch(ctx context.Context, project, env string, interval time.Duration, onChange func(Change), opts ...Option) (Secrets, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("envcrypt: watch interval must be positive, got %v", interval)
+	}
+
+	secrets, version, err := load(ctx, project, env, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.warnings == nil {
+		cfg.warnings = os.Stderr
+	}
+
+	go watch(ctx, project, env, interval, onChange, opts, secrets, version, cfg.warnings)
+
+	return secrets, nil
+}
+
+func watch(ctx context.Context, project, env string, interval time.Duration, onChange func(Change), opts []Option, secrets Secrets, version int32, warnings io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		latest, latestVersion, err := load(ctx, project, env, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(warnings, "warning: refreshing %s failed: %v\n", env, err)
+			}
+			continue
+		}
+		if latestVersion == version {
+			continue
+		}
+
+		diff := cryptutils.DiffEnvVersions(secrets, latest)
+		for _, keys := range [][]string{diff.Added, diff.Modified, diff.Removed} {
+			slices.Sort(keys)
+		}
+		secrets, version = latest, latestVersion
+		onChange(Change{
+			Secrets:  latest,
+			Version:  latestVersion,
+			Added:    diff.Added,
+			Modified: diff.Modified,
+			Removed:  diff.Removed,
+		})
+	}
+}